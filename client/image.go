@@ -0,0 +1,76 @@
+package client
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	uploadChunkSize   = 32 << 10
+	maxUploadAttempts = 5
+	// Delay before resuming an upload, multiplied by the attempt.
+	uploadRetryDelay = 200 * time.Millisecond
+	// Max time an upload can stall (not sending a chunk, or
+	// waiting for the server to save the image) before resuming it.
+	uploadIdleTimeout = 30 * time.Second
+)
+
+// fileDigest returns the size and the SHA-256 of `file`.
+func fileDigest(file io.Reader) (uint64, []byte, error) {
+	digest := sha256.New()
+	size, err := io.Copy(digest, file)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return uint64(size), digest.Sum(nil), nil
+}
+
+// isRetryableUpload tells whether an upload failed
+// with an error that resuming it can fix.
+func isRetryableUpload(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.DeadlineExceeded, codes.FailedPrecondition:
+		return true
+	default:
+		return false
+	}
+}
+
+// receiveChunks writes the image chunks received from
+// `stream` to `file`, until the whole image is received.
+func receiveChunks(stream pb.LaptopService_DownloadImageClient, file io.Writer, size uint64) error {
+	received := uint64(0)
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot receive image chunk: %v", err)
+		}
+
+		chunk := res.GetChunkData()
+		received += uint64(len(chunk))
+		if received > size {
+			return fmt.Errorf("Image is larger than expected: more than %d bytes", size)
+		}
+
+		_, err = file.Write(chunk)
+		if err != nil {
+			return fmt.Errorf("Cannot write image chunk: %v", err)
+		}
+	}
+
+	if received != size {
+		return fmt.Errorf("Image is incomplete: %d bytes out of %d", received, size)
+	}
+
+	return nil
+}
@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -11,9 +10,11 @@ import (
 	"time"
 
 	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type LaptopClient struct {
@@ -55,14 +56,100 @@ func (client *LaptopClient) CreateLaptop(laptop *pb.Laptop) {
 	}
 }
 
-func (client *LaptopClient) SearchLaptop(filter *pb.Filter) {
-	log.Print("Going to search laptop: ", filter)
+func (client *LaptopClient) GetLaptop(laptopId string) (*pb.Laptop, error) {
+	log.Printf("Going to get laptop %s", laptopId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetLaptopRequest{Id: laptopId}
+	res, err := client.service.GetLaptop(ctx, req)
+	if err != nil {
+		st, ok := status.FromError(err)
+		if ok && st.Code() == codes.NotFound {
+			// Same as the stores: no laptop, no error.
+			log.Printf("Laptop %s doesn't exist", laptopId)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Cannot get laptop %s: %v", laptopId, err)
+	}
+
+	laptop := res.GetLaptop()
+	log.Printf("Got laptop %s: %s %s", laptop.GetId(), laptop.GetBrand(), laptop.GetName())
+	return laptop, nil
+}
+
+// UpdateLaptop updates only the fields of `laptop` listed in `paths`.
+// `laptop` must be the last version of the laptop known by the server
+// (i.e. with the same `updated_at`).
+func (client *LaptopClient) UpdateLaptop(laptop *pb.Laptop, paths ...string) (*pb.Laptop, error) {
+	log.Printf("Going to update fields %v of laptop %s", paths, laptop.GetId())
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.UpdateLaptopRequest{
+		Laptop:     laptop,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	}
+	res, err := client.service.UpdateLaptop(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot update laptop %s: %v", laptop.GetId(), err)
+	}
+
+	log.Printf("Updated laptop %s", res.GetLaptop().GetId())
+	return res.GetLaptop(), nil
+}
+
+func (client *LaptopClient) DeleteLaptop(laptopId string) error {
+	log.Printf("Going to delete laptop %s", laptopId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DeleteLaptopRequest{Id: laptopId}
+	_, err := client.service.DeleteLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Cannot delete laptop %s: %v", laptopId, err)
+	}
+
+	log.Printf("Deleted laptop %s", laptopId)
+	return nil
+}
+
+// ListLaptops returns a page of laptops and the token of the next
+// page (empty if it's the last page).
+func (client *LaptopClient) ListLaptops(orderBy pb.ListLaptopsRequest_OrderBy, pageSize uint32, pageToken string) ([]*pb.Laptop, string, error) {
+	log.Printf("Going to list %d laptops ordered by %v", pageSize, orderBy)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.ListLaptopsRequest{
+		PageSize:  pageSize,
+		PageToken: pageToken,
+		OrderBy:   orderBy,
+	}
+	res, err := client.service.ListLaptops(ctx, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot list laptops: %v", err)
+	}
+
+	log.Printf("Listed %d laptops", len(res.GetLaptops()))
+	return res.GetLaptops(), res.GetNextPageToken(), nil
+}
+
+func (client *LaptopClient) SearchLaptop(req *pb.SearchLaptopRequest) {
+	log.Print("Going to search laptop: ", req)
 
 	// Setting the timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	req := &pb.SearchLaptopRequest{Filter: filter}
 	stream, err := client.service.SearchLaptop(ctx, req)
 	if err != nil {
 		log.Fatal("Cannot search laptop: ", err)
@@ -90,76 +177,308 @@ func (client *LaptopClient) SearchLaptop(filter *pb.Filter) {
 	}
 }
 
-func (client *LaptopClient) UploadImage(laptopId string, imagePath string) {
+// WatchLaptops calls `changed` with the current laptops matching `filter`
+// and then with each of their changes, until `ctx` is done or `changed`
+// returns an error. When the connection is lost, the watch goes on from
+// the last event received (or from the current matches again if that's
+// not possible anymore).
+func (client *LaptopClient) WatchLaptops(ctx context.Context, filter *pb.Filter, changed func(*pb.WatchLaptopsResponse) error) error {
+	log.Print("Going to watch laptops: ", filter)
+
+	req := &pb.WatchLaptopsRequest{Filter: filter}
+	for {
+		err := client.watchLaptops(ctx, req, changed)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch status.Code(err) {
+		case codes.Unavailable:
+			log.Printf("Watch interrupted, resuming from token %q: %v", req.GetResumeToken(), err)
+			time.Sleep(time.Second)
+		case codes.OutOfRange:
+			log.Print("Cannot resume watch, starting again: ", err)
+			req.ResumeToken = ""
+		default:
+			return err
+		}
+	}
+}
+
+// watchLaptops watches laptops until the stream fails,
+// keeping the last resume token in `req`.
+func (client *LaptopClient) watchLaptops(ctx context.Context, req *pb.WatchLaptopsRequest, changed func(*pb.WatchLaptopsResponse) error) error {
+	stream, err := client.service.WatchLaptops(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		err = changed(res)
+		if err != nil {
+			return err
+		}
+
+		if token := res.GetResumeToken(); len(token) > 0 {
+			req.ResumeToken = token
+		}
+	}
+}
+
+// UploadImage uploads an image of a laptop and returns its ID. When the
+// upload is interrupted, it's resumed from what the server received.
+func (client *LaptopClient) UploadImage(laptopId string, imagePath string) (string, error) {
 	// Opening the image file.
 	file, err := os.Open(imagePath)
 	if err != nil {
-		log.Fatalf("Cannot open file %s", imagePath)
+		return "", fmt.Errorf("Cannot open file %s: %v", imagePath, err)
 	}
 	defer file.Close()
 
-	// Creating the context.
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	size, digest, err := fileDigest(file)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read file %s: %v", imagePath, err)
+	}
+
+	// The session of the upload, to resume it.
+	info := &pb.UploadImageRequest_ImageInfo{
+		LaptopId:  laptopId,
+		ImageType: filepath.Ext(imagePath),
+		SessionId: uuid.New().String(),
+		TotalSize: size,
+		Sha256:    digest,
+	}
+
+	offset := uint64(0)
+	for attempt := 1; ; attempt++ {
+		res, err := client.uploadImage(info, file, offset)
+		if err == nil {
+			log.Printf("Image uploaded with ID %s and size %d", res.GetId(), res.GetSize())
+			return res.GetId(), nil
+		}
+		if attempt == maxUploadAttempts || !isRetryableUpload(err) {
+			return "", fmt.Errorf("Cannot upload image %s: %v", imagePath, err)
+		}
+
+		log.Printf("Upload of image %s interrupted: %v", imagePath, err)
+		time.Sleep(time.Duration(attempt) * uploadRetryDelay)
+
+		offset, err = client.QueryUpload(info.GetSessionId())
+		if status.Code(err) == codes.NotFound {
+			offset = 0 // nothing received, starting again
+		} else if err != nil {
+			return "", err
+		}
+		log.Printf("Resuming upload of image %s at offset %d", imagePath, offset)
+	}
+}
+
+// uploadImage uploads `file` from `offset`, in a single request.
+func (client *LaptopClient) uploadImage(info *pb.UploadImageRequest_ImageInfo, file *os.File, offset uint64) (res *pb.UploadImageResponse, err error) {
+	// No overall timeout, as it depends on the size of the image, but
+	// an idle one: the request is canceled when it stops making progress.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	idle := time.AfterFunc(uploadIdleTimeout, cancel)
+	defer func() {
+		idle.Stop()
+		if err != nil && ctx.Err() != nil {
+			// Retryable, so that the upload is resumed.
+			err = status.Errorf(codes.DeadlineExceeded, "Upload stalled for %v: %v", uploadIdleTimeout, err)
+		}
+	}()
 
 	// Creating the stream.
 	stream, err := client.service.UploadImage(ctx)
 	if err != nil {
-		// Also printing the "real" error
-		log.Fatal("Cannot upload image", err, stream.RecvMsg(nil))
+		return nil, err
 	}
 
 	// First sending the meta-data request.
-	metaReq := &pb.UploadImageRequest{
-		Data: &pb.UploadImageRequest_Info{
-			Info: &pb.UploadImageRequest_ImageInfo{
-				LaptopId:  laptopId,
-				ImageType: filepath.Ext(imagePath),
-			},
-		},
+	infoReq := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{Info: info},
 	}
-	err = stream.Send(metaReq)
+	err = stream.Send(infoReq)
 	if err != nil {
-		// Also printing the "real" error
-		log.Fatal("Cannot send meta request to upload image", err, stream.RecvMsg(nil))
+		// The "real" error is returned when receiving.
+		_, err = stream.CloseAndRecv()
+		return nil, err
+	}
+
+	_, err = file.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return nil, err
 	}
-	log.Print("Meta request for uploading the image has been sent to the server")
 
 	// Sending the image in chunks.
-	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1<<10)
-	log.Print("Going to send the image in chunks")
+	buffer := make([]byte, uploadChunkSize)
 	for {
-		n, err := reader.Read(buffer)
+		n, err := file.Read(buffer)
 		if err == io.EOF {
-			log.Print("Finished reading the image")
 			break
 		}
 		if err != nil {
-			log.Fatal("Cannot read chunk to buffer", err)
+			return nil, err
 		}
 
 		chunkReq := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: &pb.UploadImageRequest_ImageChunk{Offset: offset, Data: buffer[:n]},
 			},
 		}
 		err = stream.Send(chunkReq)
 		if err != nil {
-			// Also printing the "real" error
-			log.Fatalf("Cannot send image chunk of size %d: %v (%v)", n, err, stream.RecvMsg(nil))
+			_, err = stream.CloseAndRecv()
+			return nil, err
 		}
-		log.Print("Sent image chunk of size", n)
+		offset += uint64(n)
+		idle.Reset(uploadIdleTimeout)
 	}
 
 	// Waiting for the server to answer.
-	log.Print("Waiting for the server to finish uploading the image...")
-	res, err := stream.CloseAndRecv()
+	return stream.CloseAndRecv()
+}
+
+// QueryUpload returns the offset to resume an upload session from.
+func (client *LaptopClient) QueryUpload(sessionId string) (uint64, error) {
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.QueryUploadRequest{SessionId: sessionId}
+	res, err := client.service.QueryUpload(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.GetCommittedOffset(), nil
+}
+
+// DownloadImage downloads an image and writes it to `imagePath`.
+func (client *LaptopClient) DownloadImage(imageId string, imagePath string) error {
+	return client.DownloadImageVariant(imageId, 0, imagePath)
+}
+
+// DownloadImageVariant downloads the resized variant of size `variant`
+// of an image (the original image if 0), and writes it to `imagePath`.
+func (client *LaptopClient) DownloadImageVariant(imageId string, variant uint32, imagePath string) error {
+	log.Printf("Going to download image %s (variant %d) to file %s", imageId, variant, imagePath)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DownloadImageRequest{ImageId: imageId, Variant: variant}
+	stream, err := client.service.DownloadImage(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Cannot download image %s: %v", imageId, err)
+	}
+
+	// First receiving the image info.
+	res, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("Cannot receive image info: %v", err)
+	}
+	info := res.GetInfo()
+	if info == nil {
+		return fmt.Errorf("Cannot download image %s: no image info received", imageId)
+	}
+	log.Printf("Image %s has type %s and size %d", imageId, info.GetImageType(), info.GetSize())
+
+	file, err := os.Create(imagePath)
+	if err != nil {
+		return fmt.Errorf("Cannot create file %s: %v", imagePath, err)
+	}
+
+	err = receiveChunks(stream, file, info.GetSize())
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Cannot write file %s: %v", imagePath, closeErr)
+	}
+	if err != nil {
+		// No partial images.
+		os.Remove(imagePath)
+		return err
+	}
+
+	log.Printf("Image %s downloaded to file %s", imageId, imagePath)
+	return nil
+}
+
+// ListLaptopImages returns the images of a laptop,
+// the primary image first and then by upload time.
+func (client *LaptopClient) ListLaptopImages(laptopId string) ([]*pb.ListLaptopImagesResponse_Image, error) {
+	log.Printf("Going to list the images of laptop %s", laptopId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.ListLaptopImagesRequest{LaptopId: laptopId}
+	res, err := client.service.ListLaptopImages(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list the images of laptop %s: %v", laptopId, err)
+	}
+
+	log.Printf("Laptop %s has %d images", laptopId, len(res.GetImages()))
+	return res.GetImages(), nil
+}
+
+func (client *LaptopClient) DeleteImage(imageId string) error {
+	log.Printf("Going to delete image %s", imageId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DeleteImageRequest{ImageId: imageId}
+	_, err := client.service.DeleteImage(ctx, req)
 	if err != nil {
-		// Also printing the "real" error
-		log.Fatal("Cannot receive response from the server", err, stream.RecvMsg(nil))
+		return fmt.Errorf("Cannot delete image %s: %v", imageId, err)
 	}
-	log.Printf("Image uploaded with ID %s and size %d", res.GetId(), res.GetSize())
+
+	log.Printf("Deleted image %s", imageId)
+	return nil
+}
+
+// SetPrimaryImage makes an image the primary image of its laptop.
+func (client *LaptopClient) SetPrimaryImage(imageId string) error {
+	log.Printf("Going to set the primary image %s", imageId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.SetPrimaryImageRequest{ImageId: imageId}
+	_, err := client.service.SetPrimaryImage(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Cannot set the primary image %s: %v", imageId, err)
+	}
+
+	log.Printf("Set the primary image %s", imageId)
+	return nil
+}
+
+// GetImageUsage returns the usage of the image quotas by a laptop
+// and/or by a user (nil if not requested).
+func (client *LaptopClient) GetImageUsage(laptopId string, username string) (laptop *pb.GetImageUsageResponse_Usage, user *pb.GetImageUsageResponse_Usage, err error) {
+	log.Printf("Going to get the image usage of laptop %q and user %q", laptopId, username)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetImageUsageRequest{LaptopId: laptopId, Username: username}
+	res, err := client.service.GetImageUsage(ctx, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get the image usage: %v", err)
+	}
+
+	return res.GetLaptop(), res.GetUser(), nil
 }
 
 func (client *LaptopClient) RateLaptop(laptopIds []string, scores []float64) error {
@@ -195,3 +514,173 @@ func (client *LaptopClient) RateLaptop(laptopIds []string, scores []float64) err
 
 	return <-waitResponse
 }
+
+// RetractRating removes the score of a laptop by the user (and
+// the review of the user), and returns the rating of the laptop
+// without it.
+func (client *LaptopClient) RetractRating(laptopId string) (*pb.RateLaptopResponse, error) {
+	log.Printf("Going to retract the rating of laptop %s", laptopId)
+
+	req := &pb.RateLaptopRequest{LaptopId: laptopId, Retract: true}
+	res, err := client.rateOnce(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot retract rating: %v", err)
+	}
+
+	log.Printf("Laptop %s rated by %d users", laptopId, res.GetRatedCount())
+	return res, nil
+}
+
+// ReviewLaptop rates a laptop with a review, replacing the previous
+// score and review of the user, and returns the rating of the laptop.
+func (client *LaptopClient) ReviewLaptop(laptopId string, score float64, title string, body string) (*pb.RateLaptopResponse, error) {
+	log.Printf("Going to review laptop %s", laptopId)
+
+	req := &pb.RateLaptopRequest{
+		LaptopId:    laptopId,
+		Score:       score,
+		ReviewTitle: title,
+		ReviewBody:  body,
+	}
+	res, err := client.rateOnce(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot review laptop: %v", err)
+	}
+
+	log.Printf("Laptop %s reviewed (review %s)", laptopId, res.GetReviewId())
+	return res, nil
+}
+
+// rateOnce sends a single rate-laptop request, and returns its response
+// (or the error of its rejection).
+func (client *LaptopClient) rateOnce(req *pb.RateLaptopRequest) (*pb.RateLaptopResponse, error) {
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.service.RateLaptop(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot send request: %v - %v", err, stream.RecvMsg(nil))
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot close send (client stream): %v", err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if rejection := res.GetError(); rejection != nil {
+		return nil, status.Error(codes.Code(rejection.GetCode()), rejection.GetMessage())
+	}
+
+	return res, nil
+}
+
+// ListReviews returns a page of the reviews of a laptop,
+// and the token of the next page (empty on the last page).
+func (client *LaptopClient) ListReviews(laptopId string, orderBy pb.ListReviewsRequest_OrderBy, pageToken string) ([]*pb.Review, string, error) {
+	log.Printf("Going to list the reviews of laptop %s by %v", laptopId, orderBy)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.ListReviewsRequest{LaptopId: laptopId, OrderBy: orderBy, PageToken: pageToken}
+	res, err := client.service.ListReviews(ctx, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot list reviews: %v", err)
+	}
+
+	for _, review := range res.GetReviews() {
+		log.Printf("Review by %s (%.1f, %d helpful votes): %s", review.GetAuthor(), review.GetScore(), review.GetHelpfulVotes(), review.GetTitle())
+	}
+	return res.GetReviews(), res.GetNextPageToken(), nil
+}
+
+// VoteReview votes for a review as helpful (or withdraws
+// the vote), and returns the review.
+func (client *LaptopClient) VoteReview(reviewId string, helpful bool) (*pb.Review, error) {
+	log.Printf("Going to vote for review %s: helpful = %t", reviewId, helpful)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.VoteReviewRequest{ReviewId: reviewId, Helpful: helpful}
+	res, err := client.service.VoteReview(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot vote for review: %v", err)
+	}
+
+	review := res.GetReview()
+	log.Printf("Review %s found helpful by %d users", reviewId, review.GetHelpfulVotes())
+	return review, nil
+}
+
+// GetRating returns the rating of a laptop, with the distribution of its scores.
+func (client *LaptopClient) GetRating(laptopId string) (*pb.LaptopRating, error) {
+	log.Printf("Going to get the rating of laptop %s", laptopId)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetRatingRequest{LaptopId: laptopId}
+	res, err := client.service.GetRating(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the rating of laptop %s: %v", laptopId, err)
+	}
+
+	rating := res.GetRating()
+	log.Printf("Laptop %s rated by %d users: average = %.2f", laptopId, rating.GetRatedCount(), rating.GetAverageScore())
+	return rating, nil
+}
+
+// BatchGetRatings returns the ratings of many laptops
+// at once, in the order of `laptopIds`.
+func (client *LaptopClient) BatchGetRatings(laptopIds []string) ([]*pb.LaptopRating, error) {
+	log.Printf("Going to get the ratings of %d laptops", len(laptopIds))
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.BatchGetRatingsRequest{LaptopIds: laptopIds}
+	res, err := client.service.BatchGetRatings(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the ratings: %v", err)
+	}
+
+	for _, rating := range res.GetRatings() {
+		log.Printf("Laptop %s rated by %d users: average = %.2f", rating.GetLaptopId(), rating.GetRatedCount(), rating.GetAverageScore())
+	}
+	return res.GetRatings(), nil
+}
+
+// TopRatedLaptops returns the best rated laptops matching `filter` (at most
+// `limit` of them), rated by at least `minRatings` users.
+func (client *LaptopClient) TopRatedLaptops(filter *pb.Filter, limit uint32, minRatings uint32) ([]*pb.TopRatedLaptopsResponse_RatedLaptop, error) {
+	log.Printf("Going to get the %d top rated laptops", limit)
+
+	// Setting the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.TopRatedLaptopsRequest{Filter: filter, Limit: limit, MinRatings: minRatings}
+	res, err := client.service.TopRatedLaptops(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the top rated laptops: %v", err)
+	}
+
+	for i, rated := range res.GetLaptops() {
+		log.Printf("#%d: laptop %s rated by %d users: average = %.2f", i+1, rated.GetLaptop().GetId(), rated.GetRatedCount(), rated.GetAverageScore())
+	}
+	return res.GetLaptops(), nil
+}
@@ -23,6 +23,10 @@ func receiveResponses(stream pb.LaptopService_RateLaptopClient, waitResponse cha
 			return
 		}
 
+		if rejection := res.GetError(); rejection != nil {
+			log.Printf("Rating of laptop %s rejected: %s", res.GetLaptopId(), rejection.GetMessage())
+			continue
+		}
 		log.Print("Received response: ", res)
 	}
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aleg/go-grpc-laptops/client"
+	"github.com/aleg/go-grpc-laptops/sample"
+)
+
+func testGetLaptop(client *client.LaptopClient) {
+	// First, creating a new laptop via RPC...
+	laptop := sample.NewLaptop()
+	client.CreateLaptop(laptop)
+
+	// ...then getting it back.
+	_, err := client.GetLaptop(laptop.GetId())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
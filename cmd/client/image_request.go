@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+
 	"github.com/aleg/go-grpc-laptops/client"
 	"github.com/aleg/go-grpc-laptops/sample"
 )
@@ -11,5 +13,8 @@ func testUploadImage(client *client.LaptopClient) {
 	// imgPath := "tmp/img/400-blows.jpg"
 	imgPath := "tmp/img/Eternal Sunshine of the Spotless Mind.jpg"
 	client.CreateLaptop(laptop)
-	client.UploadImage(laptop.GetId(), imgPath)
+	_, err := client.UploadImage(laptop.GetId(), imgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -60,7 +60,9 @@ func main() {
 	// laptopClient := pb.NewLaptopServiceClient(conn)
 
 	// testCreateLaptop(laptopClient)
+	// testGetLaptop(laptopClient)
 	// testSearchLaptop(laptopClient)
+	// testWatchLaptops(laptopClient)
 	// testUploadImage(laptopClient)
 	testRateLaptop(laptopClient)
 }
@@ -2,11 +2,19 @@ package main
 
 func authMethods() map[string]bool {
 	path := "/aleg.laptops.LaptopService/"
-	// SearchLaptop is accessible by everyone (even for
-	// unregistered users).
+	// SearchLaptop, WatchLaptops, DownloadImage, ListLaptopImages, GetRating,
+	// BatchGetRatings, TopRatedLaptops and ListReviews are accessible by
+	// everyone (even for unregistered users).
 	return map[string]bool{
-		path + "CreateLaptop": true,
-		path + "RateLaptop":   true,
-		path + "UploadImage":  true,
+		path + "CreateLaptop":    true,
+		path + "UpdateLaptop":    true,
+		path + "DeleteLaptop":    true,
+		path + "RateLaptop":      true,
+		path + "UploadImage":     true,
+		path + "QueryUpload":     true,
+		path + "DeleteImage":     true,
+		path + "SetPrimaryImage": true,
+		path + "GetImageUsage":   true,
+		path + "VoteReview":      true,
 	}
 }
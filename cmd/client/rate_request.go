@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/aleg/go-grpc-laptops/client"
+	"github.com/aleg/go-grpc-laptops/pb"
 	"github.com/aleg/go-grpc-laptops/sample"
 )
 
@@ -40,4 +41,24 @@ func testRateLaptop(client *client.LaptopClient) {
 			log.Fatal(err)
 		}
 	}
+
+	// Finally getting the ratings back, and the best rated laptops.
+	_, err := client.BatchGetRatings(laptopIds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = client.TopRatedLaptops(nil, uint32(n), 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// And reviewing the first laptop.
+	_, err = client.ReviewLaptop(laptopIds[0], sample.RandomLaptopScore(), "Sample review", "Reviewed by the test client.")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, err = client.ListReviews(laptopIds[0], pb.ListReviewsRequest_NEWEST, "")
+	if err != nil {
+		log.Fatal(err)
+	}
 }
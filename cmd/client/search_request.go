@@ -23,5 +23,11 @@ func testSearchLaptop(client *client.LaptopClient) {
 		MinCpuGhz:   2.5,
 		MinRam:      ram,
 	}
-	client.SearchLaptop(filter)
+	// Only the 5 cheapest ones.
+	req := &pb.SearchLaptopRequest{
+		Filter: filter,
+		SortBy: pb.SearchLaptopRequest_PRICE,
+		Limit:  5,
+	}
+	client.SearchLaptop(req)
 }
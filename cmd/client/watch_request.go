@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aleg/go-grpc-laptops/client"
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/sample"
+)
+
+func testWatchLaptops(client *client.LaptopClient) {
+	// Watching the laptops with at least 4 cores for a few seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := &pb.Filter{MinCpuCores: 4}
+	done := make(chan error)
+	go func() {
+		done <- client.WatchLaptops(ctx, filter, func(res *pb.WatchLaptopsResponse) error {
+			log.Printf("- %v laptop %s (resume token %q)", res.GetType(), res.GetLaptop().GetId(), res.GetResumeToken())
+			return nil
+		})
+	}()
+
+	// Creating a bunch of laptops while watching.
+	for i := 0; i < 5; i++ {
+		client.CreateLaptop(sample.NewLaptop())
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	err := <-done
+	log.Print("Watch stopped: ", err)
+}
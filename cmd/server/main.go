@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aleg/go-grpc-laptops/pb"
@@ -22,10 +25,45 @@ const (
 func main() {
 	port := flag.Int("port", 0, "The server port")
 	enableTLS := flag.Bool("tls", false, "Enable mutual TLS")
+	maxImageSize := flag.Int64("max-image-size", 32<<20, "The max size of an uploaded image (in bytes)")
+	maxImagePixels := flag.Int("max-image-pixels", 50<<20, "The max number of pixels of an uploaded image (width times height)")
+	imageVariants := flag.String("image-variants", "128,512", "The sizes of the resized variants of the images (in pixels, comma separated)")
+	repairImages := flag.Bool("repair-images", false, "Remove the image files without an image, and the images without their file")
+	dedupImages := flag.Bool("dedup-images", false, "Store the identical images only once")
+	keepImageMetadata := flag.Bool("keep-image-metadata", false, "Keep the metadata of the uploaded images (EXIF, XMP, ICC profile...)")
+	variantWorkers := flag.Int("variant-workers", runtime.NumCPU(), "The max number of images resized at the same time")
+	maxLaptopImages := flag.Int("max-laptop-images", 100, "The max number of images of a laptop (0 for no limit)")
+	maxLaptopBytes := flag.Int64("max-laptop-bytes", 256<<20, "The max total size of the images of a laptop (in bytes, 0 for no limit)")
+	maxUserBytes := flag.Int64("max-user-bytes", 1<<30, "The max total size of the images uploaded by a user (in bytes, 0 for no limit)")
+	ratingMin := flag.Float64("rating-min", 1, "The min score of a laptop")
+	ratingMax := flag.Float64("rating-max", 10, "The max score of a laptop")
+	ratingStep := flag.Float64("rating-step", 0, "The step between the scores of a laptop, e.g. 0.5 (0 for any score)")
+	ratingPriorMean := flag.Float64("rating-prior-mean", 5.5, "The prior score of the laptops, damping their averages to rank the top rated ones")
+	ratingPriorWeight := flag.Float64("rating-prior-weight", 10, "The number of prior scores of the laptops, damping their averages to rank the top rated ones")
 
 	flag.Parse()
 	log.Printf("Start server on port %d, TLS = %t", *port, *enableTLS)
 
+	variantSizes, err := parseSizes(*imageVariants)
+	if err != nil {
+		log.Fatal("Invalid image variants: ", err)
+	}
+	if *variantWorkers < 1 {
+		log.Fatalf("Invalid variant workers: %d (at least 1)", *variantWorkers)
+	}
+	ratingScale := service.RatingScale{
+		Min:  *ratingMin,
+		Max:  *ratingMax,
+		Step: *ratingStep,
+	}
+	ratingPrior := service.RatingPrior{
+		Mean:   *ratingPriorMean,
+		Weight: *ratingPriorWeight,
+	}
+	if err := ratingPrior.Check(ratingScale); err != nil {
+		log.Fatal("Invalid rating prior: ", err)
+	}
+
 	// Creating some users and the auth server.
 	userStore := stores.NewInMemoryUserStore()
 	createUsers(userStore)
@@ -33,9 +71,34 @@ func main() {
 	authServer := service.NewAuthServer(userStore, jwtManager)
 
 	laptopStore := stores.NewInMemoryLaptopStore()
-	imageStore := stores.NewDiskImageStore("tmp/uploaded-img")
+	var imageStore checkedImageStore
+	if *dedupImages {
+		imageStore = stores.NewContentImageStore("tmp/uploaded-img")
+	} else {
+		imageStore = stores.NewDiskImageStore("tmp/uploaded-img")
+	}
+	checkImages(imageStore, *repairImages)
 	ratingStore := stores.NewInMemoryRatingStore()
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	reviewStore := stores.NewInMemoryReviewStore()
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		imageStore,
+		ratingStore,
+		service.WithMaxImageSize(*maxImageSize),
+		service.WithMaxImagePixels(*maxImagePixels),
+		service.WithImageVariants(variantSizes...),
+		service.WithVariantWorkers(*variantWorkers),
+		service.WithStripMetadata(!*keepImageMetadata),
+		service.WithImageQuotas(service.ImageQuotas{
+			MaxImagesPerLaptop: *maxLaptopImages,
+			MaxBytesPerLaptop:  *maxLaptopBytes,
+			MaxBytesPerUser:    *maxUserBytes,
+		}),
+		service.WithRatingScale(ratingScale),
+		service.WithReviewStore(reviewStore),
+		service.WithRatingPrior(ratingPrior),
+	)
+	defer laptopServer.Close()
 
 	// Interceptors.
 	authInterceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
@@ -75,6 +138,58 @@ func main() {
 	}
 }
 
+// checkedImageStore is an image store that checks its images.
+type checkedImageStore interface {
+	stores.ImageStore
+	Check(repair bool) (*stores.ImageCheck, error)
+}
+
+// checkImages reports (and repairs if asked) the
+// inconsistencies between the images and their files.
+func checkImages(imageStore checkedImageStore, repair bool) {
+	check, err := imageStore.Check(repair)
+	if err != nil {
+		log.Fatal("Cannot check the images: ", err)
+	}
+	if check.Consistent() {
+		return
+	}
+
+	for _, path := range check.OrphanFiles {
+		log.Printf("Image file %s has no image", path)
+	}
+	for _, imageId := range check.DanglingImages {
+		log.Printf("Image %s has no file", imageId)
+	}
+	for _, path := range check.DanglingVariants {
+		log.Printf("Image variant file %s is missing", path)
+	}
+	if check.Repaired {
+		log.Print("Images repaired")
+	} else {
+		log.Print("Images not repaired: restart with -repair-images to repair them")
+	}
+}
+
+// parseSizes parses a comma separated list of sizes.
+func parseSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
 // func unaryInterceptorHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 //         log.Println("--> Unary interceptor: ", info.FullMethod)
 //         return handler(ctx, req)
@@ -9,12 +9,24 @@ import (
 
 func accessibleRoles() map[string][]string {
 	path := "/aleg.laptops.LaptopService/"
-	// SearchLaptop is accessible by everyone (even for
-	// unregistered users).
+	// SearchLaptop, WatchLaptops, DownloadImage, ListLaptopImages, GetRating,
+	// BatchGetRatings, TopRatedLaptops and ListReviews are accessible by
+	// everyone (even for unregistered users).
 	return map[string][]string{
 		path + "CreateLaptop": {"admin"},
+		path + "UpdateLaptop": {"admin"},
+		path + "DeleteLaptop": {"admin"},
 		path + "RateLaptop":   {"role1", "admin"},
-		path + "UploadImage":  {}, // no user can access
+		// The images are uploaded (and the uploads resumed) by the
+		// same users as the ratings: the ones contributing content.
+		// The uploads are owned by their users, and limited by the
+		// image quotas and the max image size and pixels.
+		path + "UploadImage":     {"role1", "admin"},
+		path + "QueryUpload":     {"role1", "admin"},
+		path + "DeleteImage":     {"admin"},
+		path + "SetPrimaryImage": {"admin"},
+		path + "GetImageUsage":   {"admin"},
+		path + "VoteReview":      {"role1", "admin"},
 	}
 
 }
@@ -0,0 +1,182 @@
+// Package images checks the content of the laptop images: only JPEG,
+// PNG, GIF and WebP images are accepted, whatever their declared type.
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // registering the decoders of `image.DecodeConfig`
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+)
+
+// SniffLen is the number of bytes needed to sniff the format of an image.
+const SniffLen = 12
+
+var (
+	ErrorUnsupported = errors.New("Unsupported image type")
+	ErrorMismatch    = errors.New("Image content doesn't match its type")
+	ErrorCorrupt     = errors.New("Corrupt image")
+	ErrorTooLarge    = errors.New("Image has too many pixels")
+)
+
+// Format is a format of image.
+type Format struct {
+	Name      string // as returned by `image.DecodeConfig`
+	Extension string // of the image files
+	MediaType string
+	aliases   []string // other extensions
+	magic     func(header []byte) bool
+}
+
+var (
+	JPEG = &Format{
+		Name:      "jpeg",
+		Extension: ".jpg",
+		MediaType: "image/jpeg",
+		aliases:   []string{".jpeg", ".jpe"},
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("\xff\xd8\xff"))
+		},
+	}
+	PNG = &Format{
+		Name:      "png",
+		Extension: ".png",
+		MediaType: "image/png",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n"))
+		},
+	}
+	GIF = &Format{
+		Name:      "gif",
+		Extension: ".gif",
+		MediaType: "image/gif",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a"))
+		},
+	}
+	WebP = &Format{
+		Name:      "webp",
+		Extension: ".webp",
+		MediaType: "image/webp",
+		magic: func(header []byte) bool {
+			return len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP"
+		},
+	}
+)
+
+// The accepted formats.
+var formats = []*Format{JPEG, PNG, GIF, WebP}
+
+// ParseType returns the format of an image type, given as
+// an extension (with or without the dot) or a media type.
+func ParseType(imageType string) (*Format, error) {
+	imageType = strings.ToLower(strings.TrimSpace(imageType))
+	if len(imageType) > 0 && !strings.HasPrefix(imageType, ".") && !strings.Contains(imageType, "/") {
+		imageType = "." + imageType
+	}
+
+	for _, format := range formats {
+		if imageType == format.Extension || imageType == format.MediaType {
+			return format, nil
+		}
+		for _, alias := range format.aliases {
+			if imageType == alias {
+				return format, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrorUnsupported, imageType)
+}
+
+// Sniff returns the format of an image from its
+// first bytes (at least `SniffLen` bytes).
+func Sniff(header []byte) (*Format, error) {
+	for _, format := range formats {
+		if format.magic(header) {
+			return format, nil
+		}
+	}
+
+	return nil, ErrorUnsupported
+}
+
+// Check checks that the first bytes of an image match the declared
+// image type (any of the accepted formats if empty), and returns
+// the actual format.
+func Check(header []byte, imageType string) (*Format, error) {
+	format, err := Sniff(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(imageType) == 0 {
+		return format, nil
+	}
+
+	declared, err := ParseType(imageType)
+	if err != nil {
+		return nil, err
+	}
+	if declared != format {
+		return nil, fmt.Errorf("%w: %s image declared as %s", ErrorMismatch, format.Name, declared.Name)
+	}
+
+	return format, nil
+}
+
+// Config is the format and the dimensions of an image.
+type Config struct {
+	Format *Format
+	Width  int // in pixels
+	Height int // in pixels
+}
+
+// DecodeConfig decodes the header of an image (only the header, the
+// pixels are not checked). Images without pixels are rejected.
+func DecodeConfig(r io.Reader) (*Config, error) {
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	format, err := Sniff(header)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{Format: format}
+	if format == WebP {
+		config.Width, config.Height, err = decodeWebPConfig(reader)
+	} else {
+		var imageConfig image.Config
+		imageConfig, _, err = image.DecodeConfig(reader)
+		config.Width, config.Height = imageConfig.Width, imageConfig.Height
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorCorrupt, err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrorCorrupt, config.Width, config.Height)
+	}
+
+	return config, nil
+}
+
+// CheckPixels checks that an image has at most `maxPixels` pixels (no
+// limit if 0), before decoding it: its pixels take 4 bytes each then.
+func CheckPixels(config *Config, maxPixels int) error {
+	pixels := int64(config.Width) * int64(config.Height)
+	if maxPixels > 0 && pixels > int64(maxPixels) {
+		return fmt.Errorf("%w: %dx%d > %d", ErrorTooLarge, config.Width, config.Height, maxPixels)
+	}
+
+	return nil
+}
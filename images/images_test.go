@@ -0,0 +1,308 @@
+package images_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/aleg/go-grpc-laptops/images"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseType(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		imageType string
+		format    *images.Format
+	}{
+		{".jpg", images.JPEG},
+		{"JPEG", images.JPEG},
+		{"image/jpeg", images.JPEG},
+		{".png", images.PNG},
+		{"gif", images.GIF},
+		{".webp", images.WebP},
+		{".bmp", nil},
+		{"/../../x", nil},
+		{"", nil},
+	}
+
+	for _, tc := range testCases {
+		format, err := images.ParseType(tc.imageType)
+		if tc.format == nil {
+			require.ErrorIs(t, err, images.ErrorUnsupported, tc.imageType)
+			continue
+		}
+		require.NoError(t, err, tc.imageType)
+		require.Equal(t, tc.format, format, tc.imageType)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	header := encode(t, images.PNG, 3, 2)[:images.SniffLen]
+
+	format, err := images.Check(header, ".png")
+	require.NoError(t, err)
+	require.Equal(t, images.PNG, format)
+
+	format, err = images.Check(header, "")
+	require.NoError(t, err)
+	require.Equal(t, images.PNG, format)
+
+	_, err = images.Check(header, ".jpg")
+	require.ErrorIs(t, err, images.ErrorMismatch)
+
+	_, err = images.Check([]byte("<html><body>"), ".png")
+	require.ErrorIs(t, err, images.ErrorUnsupported)
+}
+
+func TestDecodeConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		format *images.Format
+		err    error
+	}{
+		{"jpeg", encode(t, images.JPEG, 3, 2), images.JPEG, nil},
+		{"png", encode(t, images.PNG, 3, 2), images.PNG, nil},
+		{"gif", encode(t, images.GIF, 3, 2), images.GIF, nil},
+		{"webp_lossy", webp("VP8 ", []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 3, 0, 2, 0}), images.WebP, nil},
+		{"webp_lossless", webp("VP8L", []byte{0x2f, 2, 0x40, 0, 0, 0, 0, 0, 0, 0}), images.WebP, nil},
+		{"webp_extended", webp("VP8X", []byte{0, 0, 0, 0, 2, 0, 0, 1, 0, 0}), images.WebP, nil},
+		{"truncated", encode(t, images.PNG, 3, 2)[:20], nil, images.ErrorCorrupt},
+		{"no_pixels", []byte("GIF89a\x00\x00\x00\x00\x00\x00\x00"), nil, images.ErrorCorrupt},
+		{"bad_webp", webp("VP8 ", make([]byte, 10)), nil, images.ErrorCorrupt},
+		{"text", []byte("not an image at all"), nil, images.ErrorUnsupported},
+		{"empty", nil, nil, images.ErrorUnsupported},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			config, err := images.DecodeConfig(bytes.NewReader(tc.data))
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.format, config.Format)
+			require.Equal(t, 3, config.Width)
+			require.Equal(t, 2, config.Height)
+		})
+	}
+}
+
+// encode returns an image of `width`x`height` pixels.
+func encode(t *testing.T, format *images.Format, width int, height int) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.Black, color.White})
+
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case images.JPEG:
+		err = jpeg.Encode(&buffer, img, nil)
+	case images.PNG:
+		err = png.Encode(&buffer, img)
+	case images.GIF:
+		err = gif.Encode(&buffer, img, nil)
+	}
+	require.NoError(t, err)
+
+	return buffer.Bytes()
+}
+
+// webp returns a WebP image made of the header of its first chunk.
+func webp(chunkType string, chunk []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	buffer.WriteString(chunkType)
+	buffer.WriteString("\x00\x00\x00\x00")
+	buffer.Write(chunk)
+	return buffer.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	// Left half black, right half white.
+	img := image.NewGray(image.Rect(0, 0, 400, 100))
+	for y := 0; y < 100; y++ {
+		for x := 200; x < 400; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	testCases := []struct {
+		size   int
+		width  int
+		height int
+	}{
+		{128, 128, 32},
+		{50, 50, 12},
+		{400, 400, 100},
+		{1024, 400, 100}, // not scaled up
+	}
+
+	for _, tc := range testCases {
+		resized := images.Resize(img, tc.size)
+		require.Equal(t, image.Rect(0, 0, tc.width, tc.height), resized.Bounds(), tc.size)
+
+		// Averaged colors.
+		r, _, _, _ := resized.At(0, 0).RGBA()
+		require.Zero(t, r, tc.size)
+		r, _, _, _ = resized.At(tc.width-1, tc.height-1).RGBA()
+		require.EqualValues(t, 0xffff, r, tc.size)
+	}
+
+	// Images not starting at the origin (the right half).
+	resized := images.Resize(img.SubImage(image.Rect(200, 0, 400, 100)), 100)
+	require.Equal(t, image.Rect(0, 0, 100, 50), resized.Bounds())
+	r, _, _, _ := resized.At(0, 0).RGBA()
+	require.EqualValues(t, 0xffff, r)
+
+	// Encoded as the variants.
+	var buffer bytes.Buffer
+	err := images.Encode(&buffer, images.Resize(img, 128), images.JPEG)
+	require.NoError(t, err)
+
+	data := buffer.Bytes()
+	decoded, format, err := images.Decode(bytes.NewReader(data), 128*32)
+	require.NoError(t, err)
+	require.Equal(t, images.JPEG, format)
+	require.Equal(t, image.Rect(0, 0, 128, 32), decoded.Bounds())
+
+	// Not decoding the images with too many pixels.
+	_, _, err = images.Decode(bytes.NewReader(data), 128*32-1)
+	require.ErrorIs(t, err, images.ErrorTooLarge)
+
+	err = images.Encode(&buffer, img, images.WebP)
+	require.ErrorIs(t, err, images.ErrorUnsupported)
+}
+
+func TestStripMetadata(t *testing.T) {
+	t.Parallel()
+
+	// The fixtures have EXIF (with an orientation in the rotated ones),
+	// XMP, ICC profile and text metadata, all containing "SECRET".
+	testCases := []struct {
+		fixture string
+		width   int
+		height  int
+		rotated bool
+	}{
+		{"exif.jpg", 32, 16, false},
+		{"exif-rotated.jpg", 16, 32, true}, // rotated by 90° clockwise
+		{"exif.png", 3, 2, false},
+		{"exif-rotated.png", 2, 3, true}, // rotated by 90° counterclockwise
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.fixture, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := os.ReadFile(filepath.Join("testdata", tc.fixture))
+			require.NoError(t, err)
+			require.Contains(t, string(data), "SECRET")
+
+			var buffer bytes.Buffer
+			err = images.StripMetadata(&buffer, bytes.NewReader(data), 0)
+			require.NoError(t, err)
+			require.NotContains(t, buffer.String(), "SECRET")
+
+			stripped, _, err := images.Decode(bytes.NewReader(buffer.Bytes()), 0)
+			require.NoError(t, err)
+			require.Equal(t, image.Rect(0, 0, tc.width, tc.height), stripped.Bounds())
+
+			original, _, err := images.Decode(bytes.NewReader(data), 0)
+			require.NoError(t, err)
+			if !tc.rotated {
+				// The image data is copied as is.
+				require.Equal(t, original, stripped)
+			}
+		})
+	}
+
+	// Red on the left, blue on the right: red on the top once rotated.
+	data, err := os.ReadFile(filepath.Join("testdata", "exif-rotated.jpg"))
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	err = images.StripMetadata(&buffer, bytes.NewReader(data), 0)
+	require.NoError(t, err)
+	rotated, _, err := images.Decode(&buffer, 0)
+	require.NoError(t, err)
+	r, _, b, _ := rotated.At(8, 4).RGBA()
+	require.Greater(t, r, b)
+	r, _, b, _ = rotated.At(8, 28).RGBA()
+	require.Less(t, r, b)
+
+	// The last column on the top once rotated, pixel by pixel (lossless).
+	data, err = os.ReadFile(filepath.Join("testdata", "exif-rotated.png"))
+	require.NoError(t, err)
+	original, _, err := images.Decode(bytes.NewReader(data), 0)
+	require.NoError(t, err)
+	buffer.Reset()
+	err = images.StripMetadata(&buffer, bytes.NewReader(data), 0)
+	require.NoError(t, err)
+	rotated, _, err = images.Decode(&buffer, 0)
+	require.NoError(t, err)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			require.Equal(t, color.RGBAModel.Convert(original.At(2-y, x)), color.RGBAModel.Convert(rotated.At(x, y)))
+		}
+	}
+
+	// Not decoding the images to orient with too many pixels.
+	err = images.StripMetadata(io.Discard, bytes.NewReader(data), 3*2-1)
+	require.ErrorIs(t, err, images.ErrorTooLarge)
+
+	// Other formats copied as is.
+	gifData := encode(t, images.GIF, 3, 2)
+	buffer.Reset()
+	err = images.StripMetadata(&buffer, bytes.NewReader(gifData), 0)
+	require.NoError(t, err)
+	require.Equal(t, gifData, buffer.Bytes())
+
+	err = images.StripMetadata(io.Discard, bytes.NewReader(encode(t, images.JPEG, 3, 2)[:30]), 0)
+	require.ErrorIs(t, err, images.ErrorCorrupt)
+}
+
+func TestStripMetadataForgedPNGChunk(t *testing.T) {
+	t.Parallel()
+
+	// A chunk of almost 2GB (the max length) after the PNG header,
+	// with a few bytes only.
+	pngData := encode(t, images.PNG, 3, 2)
+	for _, chunkType := range []string{"tEXt", "eXIf", "prVt"} {
+		data := append([]byte{}, pngData[:33]...) // signature and IHDR chunk
+		data = append(data, 0x7f, 0xff, 0xff, 0xf0)
+		data = append(data, chunkType...)
+		data = append(data, "SECRET"...)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		// Rejected from the size of the image, or when reading the chunk.
+		err := images.StripMetadata(io.Discard, bytes.NewReader(data), 0)
+		require.ErrorIs(t, err, images.ErrorCorrupt, chunkType)
+		err = images.StripMetadata(io.Discard, io.MultiReader(bytes.NewReader(data)), 0)
+		require.ErrorIs(t, err, images.ErrorCorrupt, chunkType)
+
+		runtime.ReadMemStats(&after)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<30), chunkType)
+	}
+}
@@ -0,0 +1,401 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// Markers of the JPEG segments.
+const (
+	jpegSOI   = 0xd8 // start of image
+	jpegEOI   = 0xd9 // end of image
+	jpegSOS   = 0xda // start of scan (the image data follows)
+	jpegAPP1  = 0xe1 // EXIF and XMP
+	jpegAPP2  = 0xe2 // ICC profile
+	jpegAPP13 = 0xed // Photoshop (IPTC)
+	jpegCOM   = 0xfe // comment
+)
+
+// The PNG chunks of metadata.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, // EXIF
+	"iCCP": true, // ICC profile
+	"iTXt": true, // text, like XMP
+	"tEXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+// Max length of the data of a PNG chunk.
+const maxPNGChunkLength = 1<<31 - 1
+
+// Max length of the EXIF data read from a PNG image (like in a JPEG
+// segment), to find its orientation. The longer EXIF data is dropped.
+const maxPNGExifLength = 1 << 16
+
+// sizedReader is a reader that knows its size, like an `io.SectionReader`
+// or a `bytes.Reader` (when read from their start).
+type sizedReader interface {
+	Size() int64
+}
+
+// The tag of the orientation in EXIF data.
+const exifOrientationTag = 0x0112
+
+// StripMetadata copies a JPEG or PNG image from `r` to `w` without
+// its metadata (EXIF, XMP, ICC profile, comments...), like the location
+// or the camera of a photo. The EXIF orientation is applied to the
+// pixels first, re-encoding the image (otherwise the image data is
+// copied as is), if it has at most `maxPixels` pixels (see `Decode`).
+// The images of the other formats are copied as is.
+// The lengths of the PNG chunks are checked against the size of `r`,
+// if it has a `Size` method.
+func StripMetadata(w io.Writer, r io.Reader, maxPixels int) error {
+	size := int64(-1) // unknown
+	if sized, ok := r.(sizedReader); ok {
+		size = sized.Size()
+	}
+
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	format, err := Sniff(header)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case JPEG:
+		return stripJPEG(w, reader, maxPixels)
+	case PNG:
+		return stripPNG(w, reader, size, maxPixels)
+	default:
+		_, err = io.Copy(w, reader)
+		return err
+	}
+}
+
+// stripJPEG copies a JPEG image without its metadata segments,
+// and without the data after the end of the image (like the
+// previews that some cameras add).
+func stripJPEG(w io.Writer, r *bufio.Reader, maxPixels int) error {
+	// The bytes read before the image data, to
+	// decode the image if it must be oriented.
+	var read bytes.Buffer
+	in := io.TeeReader(r, &read)
+
+	var kept bytes.Buffer // segments before the image data
+	orientation := 1
+
+	var marker [2]byte
+	_, err := io.ReadFull(in, marker[:])
+	if err != nil {
+		return corrupt(err)
+	}
+	if marker != [2]byte{0xff, jpegSOI} {
+		return fmt.Errorf("%w: no JPEG start of image", ErrorCorrupt)
+	}
+	kept.Write(marker[:])
+
+	for {
+		_, err := io.ReadFull(in, marker[:])
+		if err != nil {
+			return corrupt(err)
+		}
+		if marker[0] != 0xff {
+			return fmt.Errorf("%w: invalid JPEG marker %#x", ErrorCorrupt, marker[0])
+		}
+		for marker[1] == 0xff { // fill bytes
+			_, err := io.ReadFull(in, marker[1:])
+			if err != nil {
+				return corrupt(err)
+			}
+		}
+
+		if marker[1] == jpegSOS {
+			break
+		}
+		if marker[1] == jpegEOI {
+			return fmt.Errorf("%w: no JPEG image data", ErrorCorrupt)
+		}
+		if marker[1] == 0x01 || marker[1] >= 0xd0 && marker[1] <= 0xd7 {
+			kept.Write(marker[:]) // no length
+			continue
+		}
+
+		var length [2]byte
+		_, err = io.ReadFull(in, length[:])
+		if err != nil {
+			return corrupt(err)
+		}
+		size := int(binary.BigEndian.Uint16(length[:])) - len(length)
+		if size < 0 {
+			return fmt.Errorf("%w: invalid JPEG segment length", ErrorCorrupt)
+		}
+		segment := make([]byte, size)
+		_, err = io.ReadFull(in, segment)
+		if err != nil {
+			return corrupt(err)
+		}
+
+		switch marker[1] {
+		case jpegAPP1:
+			exif := []byte("Exif\x00\x00")
+			if bytes.HasPrefix(segment, exif) {
+				if value := exifOrientation(segment[len(exif):]); value > 0 {
+					orientation = value
+				}
+			}
+		case jpegAPP2, jpegAPP13, jpegCOM:
+		default:
+			kept.Write(marker[:])
+			kept.Write(length[:])
+			kept.Write(segment)
+		}
+	}
+
+	if orientation > 1 {
+		img, _, err := Decode(io.MultiReader(&read, r), maxPixels)
+		if err != nil {
+			return err
+		}
+		return Encode(w, orient(img, orientation), JPEG)
+	}
+
+	kept.Write(marker[:])
+	_, err = kept.WriteTo(w)
+	if err != nil {
+		return err
+	}
+
+	// Copying the image data up to the end of the image. A 0xff byte of
+	// the data is followed by 0x00 or by a marker, so that the end of
+	// the image cannot be in the data.
+	for {
+		data, err := r.ReadSlice(0xff)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return nil // truncated, left to the decoders
+		}
+		if err != nil {
+			return err
+		}
+
+		next, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if next == 0xff {
+			r.UnreadByte() // maybe the marker
+			continue
+		}
+		if _, err := w.Write([]byte{next}); err != nil {
+			return err
+		}
+		if next == jpegEOI {
+			return nil
+		}
+	}
+}
+
+// stripPNG copies a PNG image of `size` bytes (-1 if unknown) without
+// its metadata chunks, and without the data after the end of the image.
+// The chunks are copied, not allocated from their (untrusted) length.
+func stripPNG(w io.Writer, r *bufio.Reader, size int64, maxPixels int) error {
+	// The bytes read before the image data, to
+	// decode the image if it must be oriented.
+	var read bytes.Buffer
+	in := io.TeeReader(r, &read)
+
+	var kept bytes.Buffer // chunks before the image data
+	orientation := 1
+
+	signature := make([]byte, 8)
+	_, err := io.ReadFull(in, signature)
+	if err != nil {
+		return corrupt(err)
+	}
+	kept.Write(signature)
+
+	// The EXIF data must be before the image data (the IDAT chunks).
+	header := make([]byte, 8) // length and type of a chunk
+	for {
+		_, err := io.ReadFull(in, header)
+		if err != nil {
+			return corrupt(err)
+		}
+		chunkType := string(header[4:])
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint32(header))
+		remaining := size - int64(read.Len())
+		if length > maxPNGChunkLength || size >= 0 && length+4 > remaining {
+			return fmt.Errorf("%w: invalid PNG chunk length", ErrorCorrupt)
+		}
+
+		switch {
+		case chunkType == "eXIf" && length <= maxPNGExifLength:
+			chunk := make([]byte, length+4) // with its CRC
+			_, err = io.ReadFull(in, chunk)
+			if err == nil {
+				if value := exifOrientation(chunk[:length]); value > 0 {
+					orientation = value
+				}
+			}
+		case pngMetadataChunks[chunkType]:
+			_, err = io.CopyN(io.Discard, in, length+4)
+		default:
+			kept.Write(header)
+			_, err = io.CopyN(&kept, in, length+4) // with its CRC
+		}
+		if err != nil {
+			return corrupt(err)
+		}
+	}
+
+	if orientation > 1 {
+		img, _, err := Decode(io.MultiReader(&read, r), maxPixels)
+		if err != nil {
+			return err
+		}
+		return Encode(w, orient(img, orientation), PNG)
+	}
+
+	_, err = kept.WriteTo(w)
+	if err != nil {
+		return err
+	}
+
+	// Copying the image data, and the chunks after it.
+	for {
+		chunkType := string(header[4:])
+		length := int64(binary.BigEndian.Uint32(header)) + 4 // with its CRC
+		if pngMetadataChunks[chunkType] {
+			_, err = io.CopyN(io.Discard, r, length)
+		} else if _, err = w.Write(header); err == nil {
+			_, err = io.CopyN(w, r, length)
+		}
+		if err != nil {
+			return corrupt(err)
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return corrupt(err)
+		}
+	}
+}
+
+// corrupt returns the error of an image that cannot be read.
+func corrupt(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %v", ErrorCorrupt, err)
+}
+
+// exifOrientation returns the orientation of an image from its EXIF
+// data, i.e. a TIFF header and directories (0 if there is none).
+func exifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(exif[2:]) != 42 {
+		return 0
+	}
+
+	// Looking for the orientation (a short) in the first directory.
+	offset := uint64(order.Uint32(exif[4:]))
+	if offset+2 > uint64(len(exif)) {
+		return 0
+	}
+	count := uint64(order.Uint16(exif[offset:]))
+	for i := uint64(0); i < count; i++ {
+		entry := exif[offset+2+i*12:]
+		if len(entry) < 12 {
+			return 0
+		}
+		if order.Uint16(entry) != exifOrientationTag {
+			continue
+		}
+
+		value := int(order.Uint16(entry[8:]))
+		if order.Uint16(entry[2:]) != 3 || value < 1 || value > 8 {
+			return 0
+		}
+		return value
+	}
+
+	return 0
+}
+
+// orient flips and/or rotates an image with an EXIF orientation
+// (from 1 to 8), to show it the right way up.
+func orient(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	newWidth, newHeight := width, height
+	if orientation >= 5 { // rotated by 90°
+		newWidth, newHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2: // flipping horizontally
+				sx = width - 1 - x
+			case 3: // rotating by 180°
+				sx, sy = width-1-x, height-1-y
+			case 4: // flipping vertically
+				sy = height - 1 - y
+			case 5: // transposing
+				sx, sy = y, x
+			case 6: // rotating by 90° clockwise
+				sx, sy = y, height-1-x
+			case 7: // transversing
+				sx, sy = width-1-y, height-1-x
+			case 8: // rotating by 90° counterclockwise
+				sx, sy = width-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+
+	return dst
+}
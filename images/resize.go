@@ -0,0 +1,112 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Quality of the encoded JPEG images.
+const jpegQuality = 85
+
+// Decode decodes a whole image of at most `maxPixels` pixels (no limit
+// if 0, see `CheckPixels`). WebP images cannot be decoded.
+func Decode(r io.Reader, maxPixels int) (image.Image, *Format, error) {
+	// Keeping what the header takes, to decode the image after it.
+	var header bytes.Buffer
+	config, err := DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.Format == WebP {
+		return nil, nil, fmt.Errorf("%w: cannot decode %s images", ErrorUnsupported, config.Format.Name)
+	}
+	if err := CheckPixels(config, maxPixels); err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrorCorrupt, err)
+	}
+
+	return img, config.Format, nil
+}
+
+// Encode encodes an image as JPEG or PNG.
+func Encode(w io.Writer, img image.Image, format *Format) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case PNG:
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("%w: cannot encode %s images", ErrorUnsupported, format.Name)
+	}
+}
+
+// Resize scales an image down to fit in `size`x`size` pixels, keeping
+// its aspect ratio (smaller images are returned as is). Each pixel is
+// the average of the pixels it replaces.
+func Resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	newWidth, newHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			newWidth, newHeight = size, height*size/width
+		} else {
+			newWidth, newHeight = width*size/height, size
+		}
+		if newWidth == 0 {
+			newWidth = 1
+		}
+		if newHeight == 0 {
+			newHeight = 1
+		}
+	}
+
+	if newWidth == width && newHeight == height {
+		return img
+	}
+
+	// Averaging premultiplied colors, so that transparent pixels
+	// don't darken the others. The image is converted a row at a
+	// time, not to hold a second copy of it in memory.
+	row := image.NewRGBA(image.Rect(0, 0, width, 1))
+	sums := make([]int, newWidth*4) // of the pixels of a row of `dst`
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := y*height/newHeight, (y+1)*height/newHeight
+		for i := range sums {
+			sums[i] = 0
+		}
+
+		for sy := y0; sy < y1; sy++ {
+			draw.Draw(row, row.Bounds(), img, image.Pt(bounds.Min.X, bounds.Min.Y+sy), draw.Src)
+			for x := 0; x < newWidth; x++ {
+				x0, x1 := x*width/newWidth, (x+1)*width/newWidth
+				sum := sums[x*4 : x*4+4]
+				for i, value := range row.Pix[x0*4 : x1*4] {
+					sum[i%4] += int(value)
+				}
+			}
+		}
+
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := x*width/newWidth, (x+1)*width/newWidth
+			count := (x1 - x0) * (y1 - y0)
+			pixel := dst.Pix[y*dst.Stride+x*4:]
+			for i, sum := range sums[x*4 : x*4+4] {
+				pixel[i] = uint8(sum / count)
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,48 @@
+package images
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// decodeWebPConfig returns the dimensions of a WebP image, read from
+// the header of its first chunk (the standard library has no WebP
+// decoder). See https://developers.google.com/speed/webp/docs/riff_container
+func decodeWebPConfig(r io.Reader) (int, int, error) {
+	// RIFF header (12 bytes), chunk header (8 bytes)
+	// and the start of the chunk (10 bytes).
+	var header [30]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	chunk := header[20:]
+	switch string(header[12:16]) {
+	case "VP8 ": // lossy
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errors.New("invalid VP8 start code")
+		}
+		width := binary.LittleEndian.Uint16(chunk[6:]) & 0x3fff
+		height := binary.LittleEndian.Uint16(chunk[8:]) & 0x3fff
+		return int(width), int(height), nil
+
+	case "VP8L": // lossless
+		if chunk[0] != 0x2f {
+			return 0, 0, errors.New("invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:])
+		width := bits&0x3fff + 1
+		height := (bits>>14)&0x3fff + 1
+		return int(width), int(height), nil
+
+	case "VP8X": // extended
+		width := uint32(chunk[4]) | uint32(chunk[5])<<8 | uint32(chunk[6])<<16
+		height := uint32(chunk[7]) | uint32(chunk[8])<<8 | uint32(chunk[9])<<16
+		return int(width) + 1, int(height) + 1, nil
+
+	default:
+		return 0, 0, errors.New("unknown WebP chunk")
+	}
+}
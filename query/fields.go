@@ -0,0 +1,386 @@
+package query
+
+import (
+	"math"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/stores"
+)
+
+// fields maps each field of a query to the function that narrows
+// down the filter with a term on that field.
+var fields = map[string]func(filter *pb.Filter, t *term) error{
+	"brand": func(filter *pb.Filter, t *term) error {
+		brands, err := t.stringSet(filter.Brands)
+		filter.Brands = brands
+		return err
+	},
+	"name": func(filter *pb.Filter, t *term) error {
+		names, err := t.stringSet(filter.Names)
+		filter.Names = names
+		return err
+	},
+	"price": func(filter *pb.Filter, t *term) error {
+		min, max, err := t.floatBounds()
+		if min != nil {
+			filter.MinPriceUsd = math.Max(filter.MinPriceUsd, *min)
+		}
+		if max != nil {
+			filter.MaxPriceUsd = tighterMax(filter.MaxPriceUsd, *max)
+		}
+		return err
+	},
+	"cpu.cores": func(filter *pb.Filter, t *term) error {
+		min, err := t.uintMin()
+		if min > filter.MinCpuCores {
+			filter.MinCpuCores = min
+		}
+		return err
+	},
+	"cpu.ghz": func(filter *pb.Filter, t *term) error {
+		min, err := t.floatMin()
+		filter.MinCpuGhz = math.Max(filter.MinCpuGhz, min)
+		return err
+	},
+	"ram": func(filter *pb.Filter, t *term) error {
+		min, err := t.memoryMin(filter.MinRam)
+		filter.MinRam = min
+		return err
+	},
+	"gpu.memory": func(filter *pb.Filter, t *term) error {
+		min, err := t.memoryMin(filter.MinGpuMemory)
+		filter.MinGpuMemory = min
+		return err
+	},
+	"gpu.brand": func(filter *pb.Filter, t *term) error {
+		brands, err := t.stringSet(filter.GpuBrands)
+		filter.GpuBrands = brands
+		return err
+	},
+	"ssd": func(filter *pb.Filter, t *term) error {
+		min, err := t.memoryMin(filter.MinSsd)
+		filter.MinSsd = min
+		return err
+	},
+	"hdd": func(filter *pb.Filter, t *term) error {
+		min, err := t.memoryMin(filter.MinHdd)
+		filter.MinHdd = min
+		return err
+	},
+	"screen.size": func(filter *pb.Filter, t *term) error {
+		min, max, err := t.float32Bounds()
+		if min != nil {
+			filter.MinScreenInch = float32(math.Max(float64(filter.MinScreenInch), *min))
+		}
+		if max != nil {
+			filter.MaxScreenInch = float32(tighterMax(float64(filter.MaxScreenInch), *max))
+		}
+		return err
+	},
+	"screen.width": func(filter *pb.Filter, t *term) error {
+		min, err := t.uintMin()
+		resolution := minScreenResolution(filter)
+		if min > resolution.Width {
+			resolution.Width = min
+		}
+		return err
+	},
+	"screen.height": func(filter *pb.Filter, t *term) error {
+		min, err := t.uintMin()
+		resolution := minScreenResolution(filter)
+		if min > resolution.Height {
+			resolution.Height = min
+		}
+		return err
+	},
+	"screen.panel": func(filter *pb.Filter, t *term) error {
+		existing := make([]int32, 0, len(filter.ScreenPanels))
+		for _, panel := range filter.ScreenPanels {
+			existing = append(existing, int32(panel))
+		}
+
+		values, err := t.enumSet(pb.Screen_Panel_value, existing)
+		filter.ScreenPanels = filter.ScreenPanels[:0]
+		for _, value := range values {
+			filter.ScreenPanels = append(filter.ScreenPanels, pb.Screen_Panel(value))
+		}
+		return err
+	},
+	"keyboard.layout": func(filter *pb.Filter, t *term) error {
+		existing := make([]int32, 0, len(filter.KeyboardLayouts))
+		for _, layout := range filter.KeyboardLayouts {
+			existing = append(existing, int32(layout))
+		}
+
+		values, err := t.enumSet(pb.Keyboard_Layout_value, existing)
+		filter.KeyboardLayouts = filter.KeyboardLayouts[:0]
+		for _, value := range values {
+			filter.KeyboardLayouts = append(filter.KeyboardLayouts, pb.Keyboard_Layout(value))
+		}
+		return err
+	},
+	"keyboard.backlit": func(filter *pb.Filter, t *term) error {
+		if err := t.requireOps(":", "="); err != nil {
+			return err
+		}
+
+		backlit, err := t.bool()
+		if err != nil {
+			return err
+		}
+		if filter.KeyboardBacklit != nil && *filter.KeyboardBacklit != backlit {
+			return t.p.errorAt(t.fieldPos, "no laptop can match %q", t.field)
+		}
+		filter.KeyboardBacklit = &backlit
+		return nil
+	},
+	"weight": func(filter *pb.Filter, t *term) error {
+		if err := t.requireOps("<", "<=", ">", ">="); err != nil {
+			return err
+		}
+
+		kg, err := t.kilograms()
+		if err != nil {
+			return err
+		}
+
+		switch t.op {
+		case "<":
+			filter.MaxWeightKg = tighterMax(filter.MaxWeightKg, math.Nextafter(kg, math.Inf(-1)))
+		case "<=":
+			filter.MaxWeightKg = tighterMax(filter.MaxWeightKg, kg)
+		case ">":
+			filter.MinWeightKg = math.Max(filter.MinWeightKg, math.Nextafter(kg, math.Inf(1)))
+		case ">=":
+			filter.MinWeightKg = math.Max(filter.MinWeightKg, kg)
+		}
+		return nil
+	},
+	"year": func(filter *pb.Filter, t *term) error {
+		min, max, err := t.uintBounds()
+		if min != nil && *min > filter.MinReleaseYear {
+			filter.MinReleaseYear = *min
+		}
+		if max != nil {
+			filter.MaxReleaseYear = uint32(tighterMax(float64(filter.MaxReleaseYear), float64(*max)))
+		}
+		return err
+	},
+}
+
+func init() {
+	fields["release_year"] = fields["year"]
+}
+
+// tighterMax returns the tighter of two upper bounds
+// (a bound of 0 means no bound).
+func tighterMax(current float64, max float64) float64 {
+	if current == 0 {
+		return max
+	}
+	return math.Min(current, max)
+}
+
+func minScreenResolution(filter *pb.Filter) *pb.Screen_Resolution {
+	if filter.MinScreenResolution == nil {
+		filter.MinScreenResolution = &pb.Screen_Resolution{}
+	}
+	return filter.MinScreenResolution
+}
+
+// stringSet returns the values of the term that are also in
+// `existing` (all of them if `existing` is empty).
+func (t *term) stringSet(existing []string) ([]string, error) {
+	if err := t.requireOps(":", "="); err != nil {
+		return existing, err
+	}
+
+	var values []string
+	for _, value := range t.strings() {
+		if stores.ContainsFold(existing, value) {
+			values = append(values, value)
+		}
+	}
+
+	// An empty set would match any laptop.
+	if len(values) == 0 {
+		return existing, t.p.errorAt(t.fieldPos, "no laptop can match %q", t.field)
+	}
+
+	return values, nil
+}
+
+// enumSet is the same as `stringSet` for enum values.
+func (t *term) enumSet(names map[string]int32, existing []int32) ([]int32, error) {
+	if err := t.requireOps(":", "="); err != nil {
+		return existing, err
+	}
+
+	parsed, err := t.enum(names)
+	if err != nil {
+		return existing, err
+	}
+
+	var values []int32
+	for _, value := range parsed {
+		if len(existing) == 0 || containsEnum(existing, value) {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return existing, t.p.errorAt(t.fieldPos, "no laptop can match %q", t.field)
+	}
+
+	return values, nil
+}
+
+func containsEnum(set []int32, value int32) bool {
+	for _, other := range set {
+		if other == value {
+			return true
+		}
+	}
+	return false
+}
+
+// floatBounds returns the lower and/or upper bound set by the term.
+// Strict bounds are turned into the closest float, as the filter
+// bounds are inclusive.
+func (t *term) floatBounds() (*float64, *float64, error) {
+	return t.boundsWith(math.Nextafter)
+}
+
+// float32Bounds is the same as `floatBounds` for the bounds of the
+// filter that are float32: the closest float64 would be rounded back
+// to the bound itself.
+func (t *term) float32Bounds() (*float64, *float64, error) {
+	return t.boundsWith(func(x float64, y float64) float64 {
+		return float64(math.Nextafter32(float32(x), float32(y)))
+	})
+}
+
+// boundsWith returns the bounds of `floatBounds`, using `nextafter`
+// to get the closest float of a strict bound.
+func (t *term) boundsWith(nextafter func(x float64, y float64) float64) (*float64, *float64, error) {
+	number, err := t.float()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var min, max float64
+	switch t.op {
+	case ":", "=":
+		min, max = number, number
+	case "<":
+		max = nextafter(number, math.Inf(-1))
+	case "<=":
+		max = number
+	case ">":
+		min = nextafter(number, math.Inf(1))
+	case ">=":
+		min = number
+	}
+
+	// An upper bound of 0 means no bound at all.
+	if t.op != ">" && t.op != ">=" && max <= 0 {
+		return nil, nil, t.p.errorAt(t.values[0].pos, "no laptop can match %q", t.field)
+	}
+
+	switch t.op {
+	case ":", "=":
+		return &min, &max, nil
+	case "<", "<=":
+		return nil, &max, nil
+	default:
+		return &min, nil, nil
+	}
+}
+
+// floatMin returns the lower bound set by the term.
+func (t *term) floatMin() (float64, error) {
+	if err := t.requireOps(">", ">="); err != nil {
+		return 0, err
+	}
+
+	min, _, err := t.floatBounds()
+	if err != nil {
+		return 0, err
+	}
+	return *min, nil
+}
+
+// uintBounds is the same as `floatBounds` for integers.
+func (t *term) uintBounds() (*uint32, *uint32, error) {
+	number, err := t.uint()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outOfRange := t.p.errorAt(t.values[0].pos, "no laptop can match %q", t.field)
+	switch t.op {
+	case ":", "=":
+		if number == 0 {
+			return nil, nil, outOfRange
+		}
+		return &number, &number, nil
+	case "<":
+		if number <= 1 {
+			return nil, nil, outOfRange
+		}
+		max := number - 1
+		return nil, &max, nil
+	case "<=":
+		if number == 0 {
+			return nil, nil, outOfRange
+		}
+		return nil, &number, nil
+	case ">":
+		if number == math.MaxUint32 {
+			return nil, nil, outOfRange
+		}
+		min := number + 1
+		return &min, nil, nil
+	default:
+		return &number, nil, nil
+	}
+}
+
+// uintMin returns the lower bound set by the term.
+func (t *term) uintMin() (uint32, error) {
+	if err := t.requireOps(">", ">="); err != nil {
+		return 0, err
+	}
+
+	min, _, err := t.uintBounds()
+	if err != nil {
+		return 0, err
+	}
+	return *min, nil
+}
+
+// memoryMin returns the tighter of `current` and
+// the lower bound set by the term.
+func (t *term) memoryMin(current *pb.Memory) (*pb.Memory, error) {
+	if err := t.requireOps(">", ">="); err != nil {
+		return current, err
+	}
+
+	bits, err := t.bits()
+	if err != nil {
+		return current, err
+	}
+
+	if t.op == ">" {
+		if bits == math.MaxUint64 {
+			return current, t.p.errorAt(t.values[0].pos, "no laptop can match %q", t.field)
+		}
+		bits++
+	}
+
+	if current != nil && stores.ToBit(current) >= bits {
+		return current, nil
+	}
+
+	return &pb.Memory{Value: bits, Unit: pb.Memory_BIT}, nil
+}
@@ -0,0 +1,326 @@
+// Package query compiles text queries such as
+//
+//	brand:dell price<2000 ram>=16GB cpu.cores>=8 screen.panel:OLED
+//
+// into search filters.
+//
+// A query is a list of terms separated by spaces, and a laptop must
+// match all of them. A term is a field, an operator (`:` or `=` for
+// equality, `<`, `<=`, `>`, `>=`) and a value. Equality accepts a
+// comma separated list of values (e.g. `brand:dell,lenovo`), and
+// values with spaces can be quoted (e.g. `name:"Thinkpad X1"`).
+// Memory values need a unit (B, KB, MB, GB or TB), weights can be
+// given in kg (default) or lb.
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/stores"
+	"google.golang.org/protobuf/proto"
+)
+
+// SyntaxError is the error returned for an invalid query.
+type SyntaxError struct {
+	Column int // 1-based position (in characters) of the error
+	Msg    string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", err.Column, err.Msg)
+}
+
+// Compile compiles `text` into a filter, narrowing down
+// `base` (which is left untouched, and can be nil).
+func Compile(text string, base *pb.Filter) (*pb.Filter, error) {
+	filter := &pb.Filter{}
+	if base != nil {
+		filter = proto.Clone(base).(*pb.Filter)
+	}
+
+	p := &parser{text: text}
+	for {
+		p.skipSpaces()
+		if p.pos == len(p.text) {
+			return filter, nil
+		}
+
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		apply, found := fields[t.field]
+		if !found {
+			return nil, p.errorAt(t.fieldPos, "unknown field %q", t.field)
+		}
+
+		err = apply(filter, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// term is a single condition of a query, e.g. `ram>=16GB`.
+type term struct {
+	p        *parser
+	field    string
+	fieldPos int
+	op       string
+	opPos    int
+	values   []value
+}
+
+type value struct {
+	text string
+	pos  int
+}
+
+// errorAt returns a syntax error at the byte offset `pos` of the query.
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	column := utf8.RuneCountInString(p.text[:pos]) + 1
+	return &SyntaxError{Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+type parser struct {
+	text string
+	pos  int // current byte offset
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.text) && isSpace(p.text[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isFieldChar(c byte) bool {
+	return c == '.' || c == '_' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func (p *parser) term() (*term, error) {
+	t := &term{p: p, fieldPos: p.pos}
+
+	for p.pos < len(p.text) && isFieldChar(p.text[p.pos]) {
+		p.pos++
+	}
+	t.field = strings.ToLower(p.text[t.fieldPos:p.pos])
+	if len(t.field) == 0 {
+		return nil, p.errorAt(p.pos, "expected a field name")
+	}
+
+	t.opPos = p.pos
+	for _, op := range []string{"<=", ">=", "<", ">", ":", "="} {
+		if strings.HasPrefix(p.text[p.pos:], op) {
+			t.op = op
+			break
+		}
+	}
+	if len(t.op) == 0 {
+		return nil, p.errorAt(p.pos, "expected an operator after %q", t.field)
+	}
+	p.pos += len(t.op)
+
+	// Values separated by commas, up to the next space.
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		t.values = append(t.values, v)
+
+		if p.pos == len(p.text) || p.text[p.pos] != ',' {
+			break
+		}
+		p.pos++ // skipping the comma
+	}
+
+	if p.pos < len(p.text) && !isSpace(p.text[p.pos]) {
+		return nil, p.errorAt(p.pos, "unexpected %q", p.text[p.pos])
+	}
+
+	return t, nil
+}
+
+func (p *parser) value() (value, error) {
+	start := p.pos
+
+	// Quoted value.
+	if p.pos < len(p.text) && p.text[p.pos] == '"' {
+		end := strings.IndexByte(p.text[p.pos+1:], '"')
+		if end < 0 {
+			return value{}, p.errorAt(start, "unterminated quoted value")
+		}
+		p.pos += end + 2
+		return value{text: p.text[start+1 : p.pos-1], pos: start}, nil
+	}
+
+	for p.pos < len(p.text) && !isSpace(p.text[p.pos]) && p.text[p.pos] != ',' && p.text[p.pos] != '"' {
+		p.pos++
+	}
+	if p.pos == start {
+		return value{}, p.errorAt(start, "expected a value")
+	}
+
+	return value{text: p.text[start:p.pos], pos: start}, nil
+}
+
+// single returns the only value of the term (lists are
+// accepted only for set fields).
+func (t *term) single() (value, error) {
+	if len(t.values) > 1 {
+		return value{}, t.p.errorAt(t.values[1].pos, "%q accepts a single value", t.field)
+	}
+	return t.values[0], nil
+}
+
+// requireOps makes sure the term uses one of `ops`.
+func (t *term) requireOps(ops ...string) error {
+	for _, op := range ops {
+		if t.op == op {
+			return nil
+		}
+	}
+	return t.p.errorAt(t.opPos, "operator %q not supported by %q", t.op, t.field)
+}
+
+// -----------------------------------------------------------------
+// Values.
+
+func (t *term) float() (float64, error) {
+	v, err := t.single()
+	if err != nil {
+		return 0, err
+	}
+
+	number, err := strconv.ParseFloat(v.text, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, t.p.errorAt(v.pos, "invalid number %q", v.text)
+	}
+
+	return number, nil
+}
+
+func (t *term) uint() (uint32, error) {
+	v, err := t.single()
+	if err != nil {
+		return 0, err
+	}
+
+	number, err := strconv.ParseUint(v.text, 10, 32)
+	if err != nil {
+		return 0, t.p.errorAt(v.pos, "invalid integer %q", v.text)
+	}
+
+	return uint32(number), nil
+}
+
+// The shifts to convert a `pb.Memory_Unit` to bits.
+var memoryUnits = map[string]uint{
+	"B":  3,
+	"KB": 13,
+	"MB": 23,
+	"GB": 33,
+	"TB": 43,
+}
+
+// bits returns a memory value (e.g. `16GB` or `1.5TB`) in bits.
+func (t *term) bits() (uint64, error) {
+	v, err := t.single()
+	if err != nil {
+		return 0, err
+	}
+
+	text := strings.TrimRight(v.text, "bBkKmMgGtT")
+	unit := strings.ToUpper(v.text[len(text):])
+	shift, found := memoryUnits[unit]
+	if !found {
+		return 0, t.p.errorAt(v.pos+len(text), "expected a memory unit (B, KB, MB, GB or TB)")
+	}
+
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, t.p.errorAt(v.pos, "invalid memory %q", v.text)
+	}
+
+	bits := math.Ldexp(number, int(shift))
+	if bits >= math.Ldexp(1, 64) {
+		return 0, t.p.errorAt(v.pos, "memory %q is too large", v.text)
+	}
+
+	return uint64(bits), nil
+}
+
+// kilograms returns a weight value (e.g. `1.8`, `1.8kg` or `4lb`) in kilograms.
+func (t *term) kilograms() (float64, error) {
+	v, err := t.single()
+	if err != nil {
+		return 0, err
+	}
+
+	text := strings.ToLower(v.text)
+	factor := 1.0
+	switch {
+	case strings.HasSuffix(text, "kg"):
+		text = strings.TrimSuffix(text, "kg")
+	case strings.HasSuffix(text, "lb"):
+		text = strings.TrimSuffix(text, "lb")
+		factor = stores.KgPerLb
+	}
+
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, t.p.errorAt(v.pos, "invalid weight %q", v.text)
+	}
+
+	return number * factor, nil
+}
+
+func (t *term) bool() (bool, error) {
+	v, err := t.single()
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(v.text) {
+	case "true", "yes":
+		return true, nil
+	case "false", "no":
+		return false, nil
+	default:
+		return false, t.p.errorAt(v.pos, "invalid boolean %q", v.text)
+	}
+}
+
+// enum returns the values of the term as values of the
+// enum whose names are in `names` (e.g. `pb.Screen_Panel_value`).
+func (t *term) enum(names map[string]int32) ([]int32, error) {
+	values := make([]int32, 0, len(t.values))
+	for _, v := range t.values {
+		number, found := names[strings.ToUpper(v.text)]
+		if !found || number == 0 { // 0 is always UNKNOWN
+			return nil, t.p.errorAt(v.pos, "invalid %s %q", t.field, v.text)
+		}
+		values = append(values, number)
+	}
+
+	return values, nil
+}
+
+func (t *term) strings() []string {
+	values := make([]string, 0, len(t.values))
+	for _, v := range t.values {
+		values = append(values, v.text)
+	}
+	return values
+}
@@ -0,0 +1,236 @@
+package query_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/query"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+
+	backlit := true
+	bits := func(value uint64) *pb.Memory {
+		return &pb.Memory{Value: value, Unit: pb.Memory_BIT}
+	}
+
+	testCases := []struct {
+		name   string
+		text   string
+		base   *pb.Filter
+		filter *pb.Filter
+	}{
+		{
+			name:   "empty",
+			text:   "  ",
+			filter: &pb.Filter{},
+		},
+		{
+			name: "example",
+			text: "brand:dell price<2000 ram>=16GB cpu.cores>=8 screen.panel:OLED",
+			filter: &pb.Filter{
+				Brands:       []string{"dell"},
+				MaxPriceUsd:  math.Nextafter(2000, 0),
+				MinRam:       bits(16 << 33),
+				MinCpuCores:  8,
+				ScreenPanels: []pb.Screen_Panel{pb.Screen_OLED},
+			},
+		},
+		{
+			name: "sets",
+			text: `brand:Dell,Lenovo name:"Thinkpad X1" gpu.brand=nvidia keyboard.layout:qwerty,azerty`,
+			filter: &pb.Filter{
+				Brands:          []string{"Dell", "Lenovo"},
+				Names:           []string{"Thinkpad X1"},
+				GpuBrands:       []string{"nvidia"},
+				KeyboardLayouts: []pb.Keyboard_Layout{pb.Keyboard_QWERTY, pb.Keyboard_AZERTY},
+			},
+		},
+		{
+			name: "intersected_sets",
+			text: "brand:dell,lenovo,apple brand:LENOVO,dell",
+			base: &pb.Filter{Brands: []string{"Dell", "Apple"}},
+			filter: &pb.Filter{
+				Brands: []string{"dell"},
+			},
+		},
+		{
+			name: "ranges",
+			text: "price>=1000 price<=1500.5 year=2019 screen.size>13 screen.size<=15.6 weight<4lb weight>=1",
+			filter: &pb.Filter{
+				MinPriceUsd:    1000,
+				MaxPriceUsd:    1500.5,
+				MinReleaseYear: 2019,
+				MaxReleaseYear: 2019,
+				MinScreenInch:  math.Nextafter32(13, float32(math.Inf(1))),
+				MaxScreenInch:  15.6,
+				MinWeightKg:    1,
+				MaxWeightKg:    math.Nextafter(4*0.45359237, 0),
+			},
+		},
+		{
+			name: "strict_screen_size_max",
+			text: "screen.size<14",
+			filter: &pb.Filter{
+				MaxScreenInch: math.Nextafter32(14, 0),
+			},
+		},
+		{
+			name: "strict_screen_size_min",
+			text: "screen.size>14",
+			filter: &pb.Filter{
+				MinScreenInch: math.Nextafter32(14, float32(math.Inf(1))),
+			},
+		},
+		{
+			name: "tightened_bounds",
+			text: "price<3000 price<2000 price<2500 release_year>2015 year>=2012 cpu.ghz>2.5 ram>512MB",
+			base: &pb.Filter{MinCpuGhz: 3, MinRam: &pb.Memory{Value: 1, Unit: pb.Memory_GIGABYTE}},
+			filter: &pb.Filter{
+				MaxPriceUsd:    math.Nextafter(2000, 0),
+				MinReleaseYear: 2016,
+				MinCpuGhz:      3,
+				MinRam:         &pb.Memory{Value: 1, Unit: pb.Memory_GIGABYTE},
+			},
+		},
+		{
+			name: "memories",
+			text: "gpu.memory>=1.5gb ssd>1TB hdd>=512kb",
+			filter: &pb.Filter{
+				MinGpuMemory: bits(3 << 32),
+				MinSsd:       bits(1<<43 + 1),
+				MinHdd:       bits(512 << 13),
+			},
+		},
+		{
+			name: "screen_and_keyboard",
+			text: "screen.width>=1920 screen.height>1079 screen.panel=ips keyboard.backlit:yes",
+			filter: &pb.Filter{
+				MinScreenResolution: &pb.Screen_Resolution{Width: 1920, Height: 1080},
+				ScreenPanels:        []pb.Screen_Panel{pb.Screen_IPS},
+				KeyboardBacklit:     &backlit,
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var base *pb.Filter
+			if tc.base != nil {
+				base = proto.Clone(tc.base).(*pb.Filter)
+			}
+
+			filter, err := query.Compile(tc.text, base)
+			require.NoError(t, err)
+			require.True(t, proto.Equal(tc.filter, filter), "expected %v, got %v", tc.filter, filter)
+
+			// The base filter is left untouched.
+			require.True(t, proto.Equal(tc.base, base))
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		text   string
+		column int
+	}{
+		{
+			name:   "unknown_field",
+			text:   "brand:dell colour:red",
+			column: 12,
+		},
+		{
+			name:   "missing_field",
+			text:   ">=8",
+			column: 1,
+		},
+		{
+			name:   "missing_operator",
+			text:   "brand",
+			column: 6,
+		},
+		{
+			name:   "missing_value",
+			text:   "price< ram>=8GB",
+			column: 7,
+		},
+		{
+			name:   "unterminated_quote",
+			text:   `name:"Thinkpad X1`,
+			column: 6,
+		},
+		{
+			name:   "unsupported_operator",
+			text:   "brand>dell",
+			column: 6,
+		},
+		{
+			name:   "invalid_number",
+			text:   "price<cheap",
+			column: 7,
+		},
+		{
+			name:   "multiple_values",
+			text:   "cpu.cores>=4,8",
+			column: 14,
+		},
+		{
+			name:   "missing_unit",
+			text:   "ram>=16",
+			column: 8,
+		},
+		{
+			name:   "invalid_enum",
+			text:   "screen.panel:OLED,CRT",
+			column: 19,
+		},
+		{
+			name:   "unknown_enum",
+			text:   "keyboard.layout:unknown",
+			column: 17,
+		},
+		{
+			name:   "negative_year",
+			text:   "year<0",
+			column: 6,
+		},
+		{
+			name:   "empty_intersection",
+			text:   "brand:dell brand:apple",
+			column: 12,
+		},
+		{
+			name:   "unicode_columns",
+			text:   `name:"Café" brand`,
+			column: 18,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := query.Compile(tc.text, nil)
+			require.Error(t, err)
+			require.Nil(t, filter)
+
+			syntaxErr, ok := err.(*query.SyntaxError)
+			require.True(t, ok, "unexpected error %v", err)
+			require.Equal(t, tc.column, syntaxErr.Column, syntaxErr.Error())
+		})
+	}
+}
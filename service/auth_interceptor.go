@@ -28,7 +28,7 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		log.Println("--> unary interceptor: ", info.FullMethod)
 
-		err := interceptor.authorize(ctx, info.FullMethod)
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
@@ -42,20 +42,33 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		log.Println("--> stream interceptor: ", info.FullMethod)
 
-		err := interceptor.authorize(stream.Context(), info.FullMethod)
+		ctx, err := interceptor.authorize(stream.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
-		return handler(srv, stream)
+		return handler(srv, &authServerStream{stream, ctx})
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
+// authServerStream is a server stream with the context returned by `authorize`.
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+// authorize checks the access token of a request, and returns the context of the
+// request with the claims of the user (see `users.FromContext`) if the method needs
+// authorization.
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
 	accessibleRoles, ok := interceptor.accessibleRoles[method]
 	if !ok {
 		// everyone can access
-		return nil
+		return ctx, nil
 	}
 
 	// NOT REALLY NEEDED, covered by the loop at the end.
@@ -67,26 +80,26 @@ func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return logError(nil, codes.Unauthenticated, "Metadata is not provided")
+		return nil, logError(nil, codes.Unauthenticated, "Metadata is not provided")
 	}
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return logError(nil, codes.Unauthenticated, "Authorization token is not provided")
+		return nil, logError(nil, codes.Unauthenticated, "Authorization token is not provided")
 	}
 
 	accessToken := values[0]
 	claims, err := interceptor.jwtManager.Verify(accessToken)
 	if err != nil {
-		return logError(err, codes.Unauthenticated, "Access token is invalid")
+		return nil, logError(err, codes.Unauthenticated, "Access token is invalid")
 	}
 
 	for _, role := range accessibleRoles {
 		if role == claims.Role {
-			return nil
+			return users.NewContext(ctx, claims), nil
 		}
 	}
 
 	msg := fmt.Sprintf("Role \"%s\" has no permission to access RPC \"%s\"", claims.Role, method)
-	return logError(nil, codes.PermissionDenied, msg)
+	return nil, logError(nil, codes.PermissionDenied, msg)
 }
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// applyFieldMask copies the fields listed in `paths` from `src` to `dst`
+// (both messages must be of the same type).
+// A path can go through nested messages (e.g. "cpu.min_ghz"), while
+// repeated fields are replaced as a whole. A field that is not set
+// in `src` gets cleared in `dst`.
+func applyFieldMask(dst proto.Message, src proto.Message, paths []string) error {
+	for _, path := range paths {
+		names := strings.Split(path, ".")
+		err := applyFieldPath(dst.ProtoReflect(), src.ProtoReflect(), names)
+		if err != nil {
+			return fmt.Errorf("Cannot apply field path %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func applyFieldPath(dst protoreflect.Message, src protoreflect.Message, names []string) error {
+	name := protoreflect.Name(names[0])
+	field := dst.Descriptor().Fields().ByName(name)
+	if field == nil {
+		return fmt.Errorf("Unknown field %q", name)
+	}
+
+	// Last field of the path: copying it.
+	if len(names) == 1 {
+		if src.Has(field) {
+			dst.Set(field, src.Get(field))
+		} else {
+			dst.Clear(field)
+		}
+		return nil
+	}
+
+	// Otherwise going down the nested message.
+	if field.Message() == nil || field.IsList() || field.IsMap() {
+		return fmt.Errorf("Field %q is not a nested message", name)
+	}
+
+	return applyFieldPath(dst.Mutable(field).Message(), src.Get(field).Message(), names[1:])
+}
@@ -2,21 +2,40 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aleg/go-grpc-laptops/client"
 	"github.com/aleg/go-grpc-laptops/pb"
 	"github.com/aleg/go-grpc-laptops/sample"
 	"github.com/aleg/go-grpc-laptops/serializer"
 	"github.com/aleg/go-grpc-laptops/service"
 	"github.com/aleg/go-grpc-laptops/stores"
+	"github.com/aleg/go-grpc-laptops/users"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestClientCreateLaptop(t *testing.T) {
@@ -43,10 +62,226 @@ func TestClientCreateLaptop(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, other)
 
-	// Check that the saved laptop is the same as the one sent.
+	// Check that the store refreshed `updated_at`...
+	require.False(t, other.GetUpdatedAt().AsTime().Before(laptop.GetUpdatedAt().AsTime()))
+
+	// ...and that the rest of the saved laptop is the same as the one sent.
+	laptop.UpdatedAt = other.GetUpdatedAt()
 	requireSameLaptop(t, laptop, other)
 }
 
+func TestClientGetLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	conn := startBufconnLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := client.NewLaptopClient(conn)
+
+	// Existing laptop.
+	stored, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	other, err := laptopClient.GetLaptop(laptop.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	requireSameLaptop(t, stored, other)
+
+	// Unknown laptop: no laptop, no error.
+	other, err = laptopClient.GetLaptop(sample.NewLaptop().GetId())
+	require.NoError(t, err)
+	require.Nil(t, other)
+
+	// Status codes returned by the server.
+	service := pb.NewLaptopServiceClient(conn)
+	testCases := []struct {
+		name string
+		id   string
+		code codes.Code
+	}{
+		{name: "not_found", id: sample.NewLaptop().GetId(), code: codes.NotFound},
+		{name: "invalid_id", id: "invalid-uuid", code: codes.InvalidArgument},
+		{name: "empty_id", id: "", code: codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		req := &pb.GetLaptopRequest{Id: tc.id}
+		res, err := service.GetLaptop(context.Background(), req)
+		require.Error(t, err, tc.name)
+		require.Nil(t, res, tc.name)
+		require.Equal(t, tc.code, status.Code(err), tc.name)
+	}
+}
+
+func TestClientUpdateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	err := laptopStore.Save(sample.NewLaptop())
+	require.NoError(t, err)
+
+	conn := startBufconnLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := client.NewLaptopClient(conn)
+
+	laptop := sample.NewLaptop()
+	err = laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	// Two admins read the same version of the laptop.
+	laptop1, err := laptopClient.GetLaptop(laptop.GetId())
+	require.NoError(t, err)
+	laptop2, err := laptopClient.GetLaptop(laptop.GetId())
+	require.NoError(t, err)
+
+	// The first one fixes the price and adds a GPU...
+	laptop1.PriceUsd = 999
+	laptop1.Gpus = append(laptop1.Gpus, sample.NewGPU())
+	laptop1.Name = "not updated"
+	updated, err := laptopClient.UpdateLaptop(laptop1, "price_usd", "gpus")
+	require.NoError(t, err)
+	require.Equal(t, 999.0, updated.GetPriceUsd())
+	require.Len(t, updated.GetGpus(), 2)
+	require.Equal(t, laptop.GetName(), updated.GetName())
+	require.True(t, updated.GetUpdatedAt().AsTime().After(laptop1.GetUpdatedAt().AsTime()))
+
+	// ...so the second one is working on an outdated laptop.
+	laptop2.PriceUsd = 1
+	_, err = laptopClient.UpdateLaptop(laptop2, "price_usd")
+	require.Error(t, err)
+
+	stored, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	requireSameLaptop(t, updated, stored)
+}
+
+func TestClientDeleteLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageFolder := t.TempDir()
+	imageStore := stores.NewDiskImageStore(imageFolder)
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	// Another laptop, whose data must survive.
+	otherLaptop := sample.NewLaptop()
+	err = laptopStore.Save(otherLaptop)
+	require.NoError(t, err)
+
+	imagePaths := make([]string, 0, 3)
+	for _, laptopId := range []string{laptop.GetId(), laptop.GetId(), otherLaptop.GetId()} {
+		imageId, err := imageStore.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 128)))
+		require.NoError(t, err)
+		imagePaths = append(imagePaths, fmt.Sprintf("%s/%s.png", imageFolder, imageId))
+
+		_, err = ratingStore.Rate(laptopId, "kay", 8)
+		require.NoError(t, err)
+	}
+
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, ratingStore)
+	laptopClient := client.NewLaptopClient(conn)
+
+	err = laptopClient.DeleteLaptop(laptop.GetId())
+	require.NoError(t, err)
+
+	// The laptop is gone, together with its images and rating...
+	other, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Nil(t, other)
+	require.NoFileExists(t, imagePaths[0])
+	require.NoFileExists(t, imagePaths[1])
+	rating, err := ratingStore.Rate(laptop.GetId(), "kay", 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, rating.Count)
+
+	// ...while the other laptop has still everything.
+	other, err = laptopStore.Find(otherLaptop.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	require.FileExists(t, imagePaths[2])
+	rating, err = ratingStore.Rate(otherLaptop.GetId(), "jay", 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, rating.Count)
+
+	// A laptop can be deleted only once.
+	service := pb.NewLaptopServiceClient(conn)
+	_, err = service.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestClientListLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	n := 7
+	for i := 0; i < n; i++ {
+		laptop := sample.NewLaptop()
+		if i%2 == 0 {
+			laptop.PriceUsd = 2000 // some ties, sorted by ID
+		}
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	conn := startBufconnLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := client.NewLaptopClient(conn)
+
+	var laptops []*pb.Laptop
+	pageToken := ""
+	for pages := 1; ; pages++ {
+		page, next, err := laptopClient.ListLaptops(pb.ListLaptopsRequest_PRICE, 3, pageToken)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 3)
+		laptops = append(laptops, page...)
+
+		if len(next) == 0 {
+			require.Equal(t, 3, pages)
+			break
+		}
+		pageToken = next
+	}
+
+	require.Len(t, laptops, n)
+	for i := 1; i < n; i++ {
+		prev, curr := laptops[i-1], laptops[i]
+		require.True(t, prev.GetPriceUsd() < curr.GetPriceUsd() ||
+			(prev.GetPriceUsd() == curr.GetPriceUsd() && prev.GetId() < curr.GetId()))
+	}
+
+	// A token is still valid after its laptop is deleted.
+	_, next, err := laptopClient.ListLaptops(pb.ListLaptopsRequest_PRICE, 3, "")
+	require.NoError(t, err)
+	err = laptopStore.Delete(laptops[2].GetId())
+	require.NoError(t, err)
+	page, _, err := laptopClient.ListLaptops(pb.ListLaptopsRequest_PRICE, 3, next)
+	require.NoError(t, err)
+	require.Equal(t, laptops[3].GetId(), page[0].GetId())
+
+	// A token is only valid for its own order.
+	service := pb.NewLaptopServiceClient(conn)
+	testCases := []struct {
+		name    string
+		token   string
+		orderBy pb.ListLaptopsRequest_OrderBy
+	}{
+		{name: "other_order", token: next, orderBy: pb.ListLaptopsRequest_RELEASE_YEAR},
+		{name: "invalid_token", token: "invalid-token", orderBy: pb.ListLaptopsRequest_PRICE},
+		{name: "unknown_order", orderBy: pb.ListLaptopsRequest_OrderBy(100)},
+	}
+
+	for _, tc := range testCases {
+		req := &pb.ListLaptopsRequest{PageToken: tc.token, OrderBy: tc.orderBy}
+		_, err := service.ListLaptops(context.Background(), req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), tc.name)
+	}
+}
+
 func TestClientSearchLaptop(t *testing.T) {
 	t.Parallel()
 
@@ -116,12 +351,297 @@ func TestClientSearchLaptop(t *testing.T) {
 	require.Equal(t, len(expectedIDs), found)
 }
 
+func TestClientSearchLaptopSorted(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+	minRam := &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+
+	// The expected results, from the cheapest.
+	var qualified []*pb.Laptop
+	for i := 0; i < 30; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = float64(1000 + (i*7)%20*100) // some ties
+		if i%3 == 0 {
+			laptop.Ram = &pb.Memory{Value: 8 << 10, Unit: pb.Memory_MEGABYTE}
+		} else {
+			laptop.Ram = &pb.Memory{Value: uint64(16 + i), Unit: pb.Memory_GIGABYTE}
+			qualified = append(qualified, laptop)
+		}
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+		_, err = ratingStore.Rate(laptop.GetId(), "kay", float64(i%10))
+		require.NoError(t, err)
+	}
+	sort.Slice(qualified, func(i, j int) bool {
+		a, b := qualified[i], qualified[j]
+		if a.GetPriceUsd() != b.GetPriceUsd() {
+			return a.GetPriceUsd() < b.GetPriceUsd()
+		}
+		return a.GetId() < b.GetId()
+	})
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	search := func(req *pb.SearchLaptopRequest) []*pb.Laptop {
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var laptops []*pb.Laptop
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return laptops
+			}
+			require.NoError(t, err)
+			laptops = append(laptops, res.GetLaptop())
+		}
+	}
+	requireIds := func(expected []*pb.Laptop, actual []*pb.Laptop) {
+		require.Len(t, actual, len(expected))
+		for i := range expected {
+			require.Equal(t, expected[i].GetId(), actual[i].GetId())
+		}
+	}
+
+	// The 5 cheapest laptops with at least 16GB of RAM...
+	filter := &pb.Filter{MinRam: minRam}
+	req := &pb.SearchLaptopRequest{Filter: filter, SortBy: pb.SearchLaptopRequest_PRICE, Limit: 5}
+	requireIds(qualified[:5], search(req))
+
+	// ...the next 5...
+	req.Offset = 5
+	requireIds(qualified[5:10], search(req))
+
+	// ...and the 3 most expensive ones.
+	req = &pb.SearchLaptopRequest{Filter: filter, SortBy: pb.SearchLaptopRequest_PRICE, Descending: true, Limit: 3}
+	laptops := search(req)
+	require.Len(t, laptops, 3)
+	require.Equal(t, qualified[len(qualified)-1].GetPriceUsd(), laptops[0].GetPriceUsd())
+	require.GreaterOrEqual(t, laptops[1].GetPriceUsd(), laptops[2].GetPriceUsd())
+
+	// Sorted without limit.
+	req = &pb.SearchLaptopRequest{Filter: filter, SortBy: pb.SearchLaptopRequest_PRICE}
+	requireIds(qualified, search(req))
+
+	// Offset past the results.
+	req = &pb.SearchLaptopRequest{Filter: filter, SortBy: pb.SearchLaptopRequest_RAM, Offset: 100}
+	require.Empty(t, search(req))
+
+	// Limit without order.
+	req = &pb.SearchLaptopRequest{Filter: filter, Limit: 4}
+	require.Len(t, search(req), 4)
+
+	// Best rated laptops (only 2 laptops rated 9 have enough RAM).
+	req = &pb.SearchLaptopRequest{Filter: filter, SortBy: pb.SearchLaptopRequest_RATING, Descending: true, Limit: 3}
+	laptops = search(req)
+	require.Len(t, laptops, 3)
+	for i, score := range []float64{9, 9, 8} {
+		rating, err := ratingStore.Find(laptops[i].GetId())
+		require.NoError(t, err)
+		require.Equal(t, score, rating.Sum)
+	}
+}
+
+func TestClientSearchLaptopSortedByRating(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	// Rated 1 and 5, and two laptops never rated.
+	laptops := make([]*pb.Laptop, 4)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		err := laptopStore.Save(laptops[i])
+		require.NoError(t, err)
+	}
+	_, err := ratingStore.Rate(laptops[0].GetId(), "kay", 1)
+	require.NoError(t, err)
+	_, err = ratingStore.Rate(laptops[1].GetId(), "kay", 5)
+	require.NoError(t, err)
+	unrated := []string{laptops[2].GetId(), laptops[3].GetId()}
+	sort.Strings(unrated)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	search := func(descending bool) []string {
+		req := &pb.SearchLaptopRequest{SortBy: pb.SearchLaptopRequest_RATING, Descending: descending}
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var laptopIds []string
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return laptopIds
+			}
+			require.NoError(t, err)
+			laptopIds = append(laptopIds, res.GetLaptop().GetId())
+		}
+	}
+
+	// The laptops never rated last, in both directions.
+	expected := append([]string{laptops[0].GetId(), laptops[1].GetId()}, unrated...)
+	require.Equal(t, expected, search(false))
+	expected = append([]string{laptops[1].GetId(), laptops[0].GetId()}, unrated...)
+	require.Equal(t, expected, search(true))
+}
+
+func TestClientSearchLaptopQuery(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+
+	newLaptop := func() *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.Brand = sample.DELL
+		laptop.PriceUsd = 1500
+		laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+		laptop.Cpu.NumberCores = 8
+		laptop.Screen.Panel = pb.Screen_OLED
+		return laptop
+	}
+
+	expectedIds := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		laptop := newLaptop()
+		switch i {
+		case 0:
+			laptop.Brand = sample.LENOVO
+		case 1:
+			laptop.PriceUsd = 2000
+		case 2:
+			laptop.Ram = &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}
+		case 3:
+			laptop.Cpu.NumberCores = 4
+		case 4:
+			laptop.Screen.Panel = pb.Screen_IPS
+		default:
+			expectedIds[laptop.GetId()] = true
+		}
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	search := func(req *pb.SearchLaptopRequest) ([]*pb.Laptop, error) {
+		stream, err := laptopClient.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var laptops []*pb.Laptop
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return laptops, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			laptops = append(laptops, res.GetLaptop())
+		}
+	}
+
+	req := &pb.SearchLaptopRequest{
+		Query: "brand:dell price<2000 ram>=16GB cpu.cores>=8 screen.panel:OLED",
+	}
+	laptops, err := search(req)
+	require.NoError(t, err)
+	require.Len(t, laptops, len(expectedIds))
+	for _, laptop := range laptops {
+		require.True(t, expectedIds[laptop.GetId()])
+	}
+
+	// The query narrows down the filter.
+	req.Filter = &pb.Filter{MinCpuCores: 16}
+	laptops, err = search(req)
+	require.NoError(t, err)
+	require.Empty(t, laptops)
+
+	// Invalid query.
+	req = &pb.SearchLaptopRequest{Query: "brand:dell ram>=16"}
+	_, err = search(req)
+	require.Error(t, err)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Contains(t, st.Message(), "column 19")
+}
+
+func TestClientWatchLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	filter := &pb.Filter{MaxPriceUsd: 2000}
+
+	newLaptop := func(price float64) *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = price
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+		return laptop
+	}
+	current := newLaptop(1000)
+	newLaptop(3000)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	watch := func(ctx context.Context, resumeToken string) pb.LaptopService_WatchLaptopsClient {
+		req := &pb.WatchLaptopsRequest{Filter: filter, ResumeToken: resumeToken}
+		stream, err := laptopClient.WatchLaptops(ctx, req)
+		require.NoError(t, err)
+		return stream
+	}
+	requireEvent := func(stream pb.LaptopService_WatchLaptopsClient, eventType pb.WatchLaptopsResponse_EventType, laptopId string) string {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, eventType, res.GetType())
+		require.Equal(t, laptopId, res.GetLaptop().GetId())
+		return res.GetResumeToken()
+	}
+
+	// The current matches first.
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := watch(ctx, "")
+	require.Empty(t, requireEvent(stream, pb.WatchLaptopsResponse_CREATED, current.GetId()))
+	token := requireEvent(stream, pb.WatchLaptopsResponse_SYNCED, "")
+	require.NotEmpty(t, token)
+
+	// Then the changes.
+	created := newLaptop(1500)
+	requireEvent(stream, pb.WatchLaptopsResponse_CREATED, created.GetId())
+	cancel()
+
+	// Resuming the watch after missing some changes.
+	err := laptopStore.Delete(current.GetId())
+	require.NoError(t, err)
+	stream = watch(context.Background(), token)
+	requireEvent(stream, pb.WatchLaptopsResponse_CREATED, created.GetId())
+	requireEvent(stream, pb.WatchLaptopsResponse_DELETED, current.GetId())
+
+	// Invalid resume tokens.
+	for _, token := range []string{"not-a-token", "0", "1000000"} {
+		_, err = watch(context.Background(), token).Recv()
+		require.Error(t, err)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
 func TestClientUploadImage(t *testing.T) {
 	t.Parallel()
 
 	testImageFolder := "../tmp"
+	imageFolder := t.TempDir()
 	laptopStore := stores.NewInMemoryLaptopStore()
-	imageStore := stores.NewDiskImageStore(testImageFolder)
+	imageStore := stores.NewDiskImageStore(imageFolder)
 
 	laptop := sample.NewLaptop()
 	err := laptopStore.Save(laptop)
@@ -175,70 +695,1323 @@ func TestClientUploadImage(t *testing.T) {
 	require.NotZero(t, res.GetId())
 	require.EqualValues(t, size, res.GetSize())
 
-	savedImagePath := fmt.Sprintf("%s/%s%s", testImageFolder, res.GetId(), imageType)
+	savedImagePath := fmt.Sprintf("%s/%s%s", imageFolder, res.GetId(), imageType)
 	require.FileExists(t, savedImagePath)
-	require.NoError(t, os.Remove(savedImagePath))
 }
 
-func TestClientRateLaptop(t *testing.T) {
+func TestClientUploadImageAborted(t *testing.T) {
 	t.Parallel()
 
+	imageFolder := t.TempDir()
 	laptopStore := stores.NewInMemoryLaptopStore()
-	ratingStore := stores.NewInMemoryRatingStore()
+	imageStore := stores.NewDiskImageStore(imageFolder)
 
 	laptop := sample.NewLaptop()
 	err := laptopStore.Save(laptop)
 	require.NoError(t, err)
 
-	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
-	laptopClient := newTestLaptopClient(t, serverAddress)
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil, service.WithMaxImageSize(4<<10), service.WithImageVariants())
+	laptopClient := pb.NewLaptopServiceClient(conn)
 
-	stream, err := laptopClient.RateLaptop(context.Background())
-	require.NoError(t, err)
+	imageData := newTestImage(t, 5<<10)
 
-	scores := []float64{8, 7.5, 10}
-	averages := []float64{8, 7.75, 8.5}
+	upload := func(ctx context.Context, chunks int) (pb.LaptopService_UploadImageClient, error) {
+		stream, err := laptopClient.UploadImage(ctx)
+		require.NoError(t, err)
 
-	n := len(scores)
-	for i := 0; i < n; i++ {
-		req := &pb.RateLaptopRequest{
-			LaptopId: laptop.GetId(),
-			Score:    scores[i],
+		infoReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{
+				Info: &pb.UploadImageRequest_ImageInfo{LaptopId: laptop.GetId(), ImageType: ".png"},
+			},
 		}
-
-		err := stream.Send(req)
+		err = stream.Send(infoReq)
 		require.NoError(t, err)
+
+		for i := 0; i < chunks; i++ {
+			chunkReq := &pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{ChunkData: imageData[i<<10 : (i+1)<<10]},
+			}
+			err = stream.Send(chunkReq)
+			if err != nil {
+				return stream, err
+			}
+		}
+
+		return stream, nil
+	}
+	requireNoFiles := func() {
+		require.Eventually(t, func() bool {
+			return len(imageFiles(t, imageFolder)) == 0
+		}, 5*time.Second, 10*time.Millisecond)
 	}
 
-	err = stream.CloseSend()
+	// Too large.
+	stream, _ := upload(context.Background(), 5)
+	_, err = stream.CloseAndRecv()
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	requireNoFiles()
+
+	// Cancelled by the client.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = upload(ctx, 2)
 	require.NoError(t, err)
+	cancel()
+	requireNoFiles()
 
-	for idx := 0; ; idx++ {
-		res, err := stream.Recv()
-		if err == io.EOF {
-			require.Equal(t, n, idx)
-			return
-		}
+	// Under the limit.
+	stream, err = upload(context.Background(), 4)
+	require.NoError(t, err)
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.EqualValues(t, 4<<10, res.GetSize())
 
-		require.NoError(t, err)
-		require.Equal(t, laptop.GetId(), res.GetLaptopId())
-		require.Equal(t, uint32(idx+1), res.GetRatedCount())
-		require.Equal(t, averages[idx], res.GetAverageScore())
-	}
+	require.Equal(t, []string{res.GetId() + ".png"}, imageFiles(t, imageFolder))
 }
 
-func startTestLaptopServer(t *testing.T, laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore) string {
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+func TestClientUploadImageInvalid(t *testing.T) {
+	t.Parallel()
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	imageFolder := t.TempDir()
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(imageFolder)
 
-	listener, err := net.Listen("tcp", ":0") // random available port
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
 	require.NoError(t, err)
 
-	go grpcServer.Serve(listener)
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil, service.WithImageVariants(), service.WithMaxImagePixels(1000))
+	laptopClient := pb.NewLaptopServiceClient(conn)
 
-	return listener.Addr().String()
+	upload := func(imageType string, imageData []byte) (*pb.UploadImageResponse, error) {
+		stream, err := laptopClient.UploadImage(context.Background())
+		require.NoError(t, err)
+
+		infoReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{
+				Info: &pb.UploadImageRequest_ImageInfo{LaptopId: laptop.GetId(), ImageType: imageType},
+			},
+		}
+		err = stream.Send(infoReq)
+		require.NoError(t, err)
+
+		chunkReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: imageData},
+		}
+		stream.Send(chunkReq) // the error is returned when receiving
+
+		return stream.CloseAndRecv()
+	}
+
+	imageData := newTestImage(t, 128)
+	largeData, err := os.ReadFile("../tmp/test-400-blows.jpg")
+	require.NoError(t, err)
+	testCases := []struct {
+		name      string
+		imageType string
+		imageData []byte
+	}{
+		{"path_traversal", "/../../laptop", imageData},
+		{"unsupported_type", ".exe", imageData},
+		{"mismatch", ".jpg", imageData},
+		{"not_an_image", ".png", []byte("#!/bin/sh\necho hello\n")},
+		{"corrupt", ".png", imageData[:20]},
+		{"too_many_pixels", ".jpg", largeData},
+	}
+
+	for _, tc := range testCases {
+		_, err := upload(tc.imageType, tc.imageData)
+		require.Error(t, err, tc.name)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), tc.name)
+	}
+
+	// The type is detected when not declared.
+	res, err := upload("", imageData)
+	require.NoError(t, err)
+
+	info, err := imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	require.Equal(t, ".png", info.Type)
+	require.Equal(t, 3, info.Width)
+	require.Equal(t, 2, info.Height)
+
+	require.Equal(t, []string{res.GetId() + ".png"}, imageFiles(t, imageFolder))
+}
+
+func TestClientUploadImageResumed(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(imageFolder)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	// Keeping the padding of the images, to compare them.
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil, service.WithImageVariants(), service.WithStripMetadata(false))
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	imageData := newTestImage(t, 4<<10)
+	digest := sha256.Sum256(imageData)
+	info := &pb.UploadImageRequest_ImageInfo{
+		LaptopId:  laptop.GetId(),
+		ImageType: ".png",
+		SessionId: uuid.New().String(),
+		TotalSize: uint64(len(imageData)),
+		Sha256:    digest[:],
+	}
+
+	// Sends the chunks of the image between `from` and `to`.
+	upload := func(ctx context.Context, info *pb.UploadImageRequest_ImageInfo, from int, to int) pb.LaptopService_UploadImageClient {
+		stream, err := laptopClient.UploadImage(ctx)
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{Info: info}})
+		require.NoError(t, err)
+
+		for offset := from; offset < to; offset += 1 << 10 {
+			chunkReq := &pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_Chunk{
+					Chunk: &pb.UploadImageRequest_ImageChunk{
+						Offset: uint64(offset),
+						Data:   imageData[offset : offset+1<<10],
+					},
+				},
+			}
+			err = stream.Send(chunkReq)
+			require.NoError(t, err)
+		}
+
+		return stream
+	}
+	requireCode := func(stream pb.LaptopService_UploadImageClient, code codes.Code) {
+		_, err := stream.CloseAndRecv()
+		require.Error(t, err)
+		require.Equal(t, code, status.Code(err))
+	}
+	queryUpload := func(sessionId string) uint64 {
+		res, err := laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{SessionId: sessionId})
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(imageData)), res.GetTotalSize())
+		return res.GetCommittedOffset()
+	}
+
+	// Interrupted upload.
+	ctx, cancel := context.WithCancel(context.Background())
+	upload(ctx, info, 0, 2<<10)
+	require.Eventually(t, func() bool {
+		return queryUpload(info.GetSessionId()) == 2<<10
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	// Incomplete upload.
+	require.Eventually(t, func() bool {
+		_, err := laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{SessionId: info.GetSessionId()})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	requireCode(upload(context.Background(), info, 2<<10, 3<<10), codes.FailedPrecondition)
+	require.Equal(t, uint64(3<<10), queryUpload(info.GetSessionId()))
+
+	// Wrong offset or image info.
+	requireCode(upload(context.Background(), info, 2<<10, 4<<10), codes.FailedPrecondition)
+	otherInfo := proto.Clone(info).(*pb.UploadImageRequest_ImageInfo)
+	otherInfo.ImageType = ".gif"
+	requireCode(upload(context.Background(), otherInfo, 3<<10, 4<<10), codes.InvalidArgument)
+
+	// Resumed upload.
+	stream := upload(context.Background(), info, 3<<10, 4<<10)
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.EqualValues(t, len(imageData), res.GetSize())
+
+	saved, err := imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	savedData, err := os.ReadFile(saved.Path)
+	require.NoError(t, err)
+	require.Equal(t, imageData, savedData)
+
+	_, err = laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{SessionId: info.GetSessionId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Wrong digest: the session is discarded.
+	info.SessionId = uuid.New().String()
+	info.Sha256 = make([]byte, sha256.Size)
+	requireCode(upload(context.Background(), info, 0, 4<<10), codes.DataLoss)
+	_, err = laptopClient.QueryUpload(context.Background(), &pb.QueryUploadRequest{SessionId: info.GetSessionId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	require.Len(t, imageFiles(t, imageFolder), 1)
+}
+
+func TestClientUploadImageAutoResumed(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(t.TempDir())
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil)
+	t.Cleanup(laptopServer.Close)
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	listener := bufconn.Listen(1 << 20)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	// Breaking the first upload after its first chunk, as a lost connection would.
+	var m sync.Mutex
+	uploads := 0
+	interceptor := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		m.Lock()
+		uploads++
+		first := uploads == 1
+		m.Unlock()
+
+		if !first {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &brokenClientStream{ClientStream: stream, cancel: cancel, messages: 2}, nil
+	}
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(dialer),
+		grpc.WithInsecure(),
+		grpc.WithStreamInterceptor(interceptor),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	imagePath := "../tmp/test-400-blows.jpg"
+	imageId, err := client.NewLaptopClient(conn).UploadImage(laptop.GetId(), imagePath)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, uploads, 2)
+
+	info, err := imageStore.Find(imageId)
+	require.NoError(t, err)
+	expected, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(info.Path)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+}
+
+// brokenClientStream is a stream cancelled
+// after sending some messages.
+type brokenClientStream struct {
+	grpc.ClientStream
+	cancel   context.CancelFunc
+	messages int
+}
+
+func (stream *brokenClientStream) SendMsg(m interface{}) error {
+	if stream.messages == 0 {
+		stream.cancel()
+		return status.Error(codes.Unavailable, "connection lost")
+	}
+
+	stream.messages--
+	return stream.ClientStream.SendMsg(m)
+}
+
+func (stream *brokenClientStream) RecvMsg(m interface{}) error {
+	if stream.messages == 0 {
+		return status.Error(codes.Unavailable, "connection lost")
+	}
+
+	return stream.ClientStream.RecvMsg(m)
+}
+
+func TestClientUploadImageOtherUser(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(t.TempDir())
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	accessibleRoles := map[string][]string{
+		"/aleg.laptops.LaptopService/UploadImage": {"role1", "admin"},
+		"/aleg.laptops.LaptopService/QueryUpload": {"role1", "admin"},
+	}
+	conn, jwtManager := startAuthLaptopServer(t, laptopStore, imageStore, nil, accessibleRoles, service.WithImageVariants())
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	imageData := newTestImage(t, 2<<10)
+	digest := sha256.Sum256(imageData)
+	info := &pb.UploadImageRequest_ImageInfo{
+		LaptopId:  laptop.GetId(),
+		ImageType: ".png",
+		SessionId: uuid.New().String(),
+		TotalSize: uint64(len(imageData)),
+		Sha256:    digest[:],
+	}
+
+	// Sends the chunk of the image at `offset`.
+	upload := func(ctx context.Context, offset int) (*pb.UploadImageResponse, error) {
+		stream, err := laptopClient.UploadImage(ctx)
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{Data: &pb.UploadImageRequest_Info{Info: info}})
+		require.NoError(t, err)
+		chunkReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Chunk{
+				Chunk: &pb.UploadImageRequest_ImageChunk{
+					Offset: uint64(offset),
+					Data:   imageData[offset : offset+1<<10],
+				},
+			},
+		}
+		err = stream.Send(chunkReq)
+		require.NoError(t, err)
+
+		return stream.CloseAndRecv()
+	}
+	kay := userContext(t, jwtManager, "kay", "role1")
+	jay := userContext(t, jwtManager, "jay", "admin")
+
+	_, err = upload(kay, 0)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// The session of a user is not visible to the others.
+	res, err := laptopClient.QueryUpload(kay, &pb.QueryUploadRequest{SessionId: info.GetSessionId()})
+	require.NoError(t, err)
+	require.EqualValues(t, 1<<10, res.GetCommittedOffset())
+
+	_, err = laptopClient.QueryUpload(jay, &pb.QueryUploadRequest{SessionId: info.GetSessionId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = upload(jay, 1<<10)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Only resumed by its user.
+	uploadRes, err := upload(kay, 1<<10)
+	require.NoError(t, err)
+	require.EqualValues(t, len(imageData), uploadRes.GetSize())
+}
+
+func TestClientUploadImageQuotas(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(imageFolder)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	quotas := service.ImageQuotas{MaxImagesPerLaptop: 3, MaxBytesPerLaptop: 1 << 20, MaxBytesPerUser: 300}
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, nil, service.WithImageVariants(), service.WithStripMetadata(false), service.WithImageQuotas(quotas))
+	t.Cleanup(laptopServer.Close)
+
+	// Authenticated users, for the quotas per user.
+	jwtManager := users.NewJWTManager("secret", time.Minute)
+	path := "/aleg.laptops.LaptopService/"
+	authInterceptor := service.NewAuthInterceptor(jwtManager, map[string][]string{
+		path + "UploadImage":   {"role1", "admin"},
+		path + "GetImageUsage": {"admin"},
+	})
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.StreamInterceptor(authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	listener := bufconn.Listen(1 << 20)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	userContext := func(username string, role string) context.Context {
+		token, err := jwtManager.Generate(&users.User{Username: username, Role: role})
+		require.NoError(t, err)
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+	}
+	kay := userContext("kay", "role1")
+	jay := userContext("jay", "admin")
+
+	upload := func(ctx context.Context, totalSize uint64) error {
+		stream, err := laptopClient.UploadImage(ctx)
+		require.NoError(t, err)
+
+		infoReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{
+				Info: &pb.UploadImageRequest_ImageInfo{LaptopId: laptop.GetId(), TotalSize: totalSize},
+			},
+		}
+		err = stream.Send(infoReq)
+		require.NoError(t, err)
+
+		chunkReq := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: newTestImage(t, 128)},
+		}
+		stream.Send(chunkReq) // the error is returned when receiving
+
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	requireQuotaFailure := func(err error, subject string) {
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		details := status.Convert(err).Details()
+		require.Len(t, details, 1)
+		failure, ok := details[0].(*errdetails.QuotaFailure)
+		require.True(t, ok)
+		require.Len(t, failure.GetViolations(), 1)
+		require.Equal(t, subject, failure.GetViolations()[0].GetSubject())
+	}
+
+	// Max bytes per user, checked upfront with the announced size
+	// and while receiving the image otherwise.
+	require.NoError(t, upload(kay, 128))
+	require.NoError(t, upload(kay, 0))
+	requireQuotaFailure(upload(kay, 128), "user:kay")
+	requireQuotaFailure(upload(kay, 0), "user:kay")
+
+	// Max images per laptop, for all the users.
+	require.NoError(t, upload(jay, 128))
+	requireQuotaFailure(upload(jay, 128), "laptop:"+laptop.GetId())
+	require.Len(t, imageFiles(t, imageFolder), 3)
+
+	// Only the admins can get the usage.
+	req := &pb.GetImageUsageRequest{LaptopId: laptop.GetId(), Username: "kay"}
+	_, err = laptopClient.GetImageUsage(kay, req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := laptopClient.GetImageUsage(jay, req)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), res.GetLaptop().GetImages())
+	require.Equal(t, uint64(3*128), res.GetLaptop().GetBytes())
+	require.Equal(t, uint32(3), res.GetLaptop().GetMaxImages())
+	require.Equal(t, uint64(1<<20), res.GetLaptop().GetMaxBytes())
+	require.Equal(t, uint32(2), res.GetUser().GetImages())
+	require.Equal(t, uint64(2*128), res.GetUser().GetBytes())
+	require.Equal(t, uint64(300), res.GetUser().GetMaxBytes())
+
+	_, err = laptopClient.GetImageUsage(jay, &pb.GetImageUsageRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestClientUploadImageMetadata(t *testing.T) {
+	t.Parallel()
+
+	fixture := "../images/testdata/exif-rotated.jpg"
+	for _, strip := range []bool{true, false} {
+		imageFolder := t.TempDir()
+		laptopStore := stores.NewInMemoryLaptopStore()
+		imageStore := stores.NewDiskImageStore(imageFolder)
+
+		laptop := sample.NewLaptop()
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil, service.WithImageVariants(), service.WithStripMetadata(strip))
+		laptopClient := client.NewLaptopClient(conn)
+
+		imageId, err := laptopClient.UploadImage(laptop.GetId(), fixture)
+		require.NoError(t, err)
+
+		info, err := imageStore.Find(imageId)
+		require.NoError(t, err)
+		data, err := os.ReadFile(info.Path)
+		require.NoError(t, err)
+		require.EqualValues(t, len(data), info.Size)
+
+		if strip {
+			// Rotated by its EXIF orientation, then stripped.
+			require.NotContains(t, string(data), "SECRET")
+			require.Equal(t, 16, info.Width)
+			require.Equal(t, 32, info.Height)
+		} else {
+			require.Contains(t, string(data), "SECRET")
+			require.Equal(t, 32, info.Width)
+			require.Equal(t, 16, info.Height)
+		}
+	}
+}
+
+func TestClientDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(t.TempDir())
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	// Larger than a chunk.
+	imageData, err := os.ReadFile("../tmp/test-400-blows.jpg")
+	require.NoError(t, err)
+	imageId, err := imageStore.Save(laptop.GetId(), ".jpg", bytes.NewReader(imageData))
+	require.NoError(t, err)
+
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := client.NewLaptopClient(conn)
+
+	imagePath := filepath.Join(t.TempDir(), "laptop.jpg")
+	err = laptopClient.DownloadImage(imageId, imagePath)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+	require.Equal(t, imageData, downloaded)
+
+	// Unknown image.
+	otherPath := filepath.Join(t.TempDir(), "other.jpg")
+	err = laptopClient.DownloadImage(uuid.New().String(), otherPath)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), codes.NotFound.String())
+	require.NoFileExists(t, otherPath)
+}
+
+func TestClientDownloadImageVariant(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(imageFolder)
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil,
+		service.WithImageVariants(128, 512),
+		service.WithVariantWorkers(1),
+	)
+	laptopClient := client.NewLaptopClient(conn)
+
+	imageId, err := laptopClient.UploadImage(laptop.GetId(), "../tmp/test-400-blows.jpg")
+	require.NoError(t, err)
+
+	// The variants are generated in the background.
+	require.Eventually(t, func() bool {
+		images, err := laptopClient.ListLaptopImages(laptop.GetId())
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		return len(images[0].GetVariants()) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	images, err := laptopClient.ListLaptopImages(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, []uint32{128, 512}, images[0].GetVariants())
+
+	for _, size := range []uint32{128, 512} {
+		imagePath := filepath.Join(t.TempDir(), "laptop.jpg")
+		err = laptopClient.DownloadImageVariant(imageId, size, imagePath)
+		require.NoError(t, err)
+
+		file, err := os.Open(imagePath)
+		require.NoError(t, err)
+		config, format, err := image.DecodeConfig(file)
+		file.Close()
+		require.NoError(t, err)
+		require.Equal(t, "jpeg", format)
+		require.EqualValues(t, size, config.Width) // landscape image
+		require.Less(t, config.Height, config.Width)
+	}
+
+	// Unknown variant.
+	otherPath := filepath.Join(t.TempDir(), "other.jpg")
+	err = laptopClient.DownloadImageVariant(imageId, 256, otherPath)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), codes.NotFound.String())
+	require.NoFileExists(t, otherPath)
+
+	// The variants are deleted with the image.
+	err = imageStore.Delete(imageId)
+	require.NoError(t, err)
+	require.Empty(t, imageFiles(t, imageFolder))
+}
+
+func TestClientLaptopImages(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	imageStore := stores.NewDiskImageStore(t.TempDir())
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	var imageIds []string
+	for i := 0; i < 3; i++ {
+		imageId, err := imageStore.Save(laptop.GetId(), ".png", bytes.NewReader(newTestImage(t, 128)))
+		require.NoError(t, err)
+		imageIds = append(imageIds, imageId)
+	}
+	otherImageId, err := imageStore.Save(uuid.New().String(), ".png", bytes.NewReader(newTestImage(t, 128)))
+	require.NoError(t, err)
+
+	conn := startBufconnLaptopServer(t, laptopStore, imageStore, nil)
+	laptopClient := client.NewLaptopClient(conn)
+
+	// Uploading a primary image.
+	primaryData, err := os.ReadFile("../tmp/test-400-blows.jpg")
+	require.NoError(t, err)
+	stream, err := pb.NewLaptopServiceClient(conn).UploadImage(context.Background())
+	require.NoError(t, err)
+	infoReq := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.UploadImageRequest_ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg", Primary: true},
+		},
+	}
+	require.NoError(t, stream.Send(infoReq))
+	chunkReq := &pb.UploadImageRequest{Data: &pb.UploadImageRequest_ChunkData{ChunkData: primaryData}}
+	require.NoError(t, stream.Send(chunkReq))
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	primaryId := res.GetId()
+
+	requireImages := func(expectedIds ...string) []*pb.ListLaptopImagesResponse_Image {
+		images, err := laptopClient.ListLaptopImages(laptop.GetId())
+		require.NoError(t, err)
+		require.Len(t, images, len(expectedIds))
+		for i, image := range images {
+			require.Equal(t, expectedIds[i], image.GetId())
+		}
+		return images
+	}
+
+	// The primary image first, then by upload time.
+	images := requireImages(primaryId, imageIds[0], imageIds[1], imageIds[2])
+	require.True(t, images[0].GetPrimary())
+	require.Equal(t, ".jpg", images[0].GetImageType())
+	require.EqualValues(t, len(primaryData), images[0].GetSize())
+	require.EqualValues(t, 1600, images[0].GetWidth())
+	require.NotNil(t, images[0].GetUploadedAt())
+	require.False(t, images[1].GetPrimary())
+
+	// Only one primary image per laptop.
+	err = laptopClient.SetPrimaryImage(imageIds[2])
+	require.NoError(t, err)
+	requireImages(imageIds[2], imageIds[0], imageIds[1], primaryId)
+
+	err = laptopClient.SetPrimaryImage(uuid.New().String())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), codes.NotFound.String())
+
+	// Deleting an image removes its file.
+	info, err := imageStore.Find(imageIds[0])
+	require.NoError(t, err)
+	err = laptopClient.DeleteImage(imageIds[0])
+	require.NoError(t, err)
+	require.NoFileExists(t, info.Path)
+	requireImages(imageIds[2], imageIds[1], primaryId)
+
+	err = laptopClient.DeleteImage(imageIds[0])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), codes.NotFound.String())
+
+	// The images of other laptops are not affected.
+	info, err = imageStore.Find(otherImageId)
+	require.NoError(t, err)
+	require.NotNil(t, info)
+
+	// Unknown laptop.
+	_, err = laptopClient.ListLaptopImages(uuid.New().String())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), codes.NotFound.String())
+}
+
+func TestClientRateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	accessibleRoles := map[string][]string{"/aleg.laptops.LaptopService/RateLaptop": {"role1", "admin"}}
+	conn, jwtManager := startAuthLaptopServer(t, laptopStore, nil, ratingStore, accessibleRoles)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	rate := func(ctx context.Context, reqs ...*pb.RateLaptopRequest) ([]*pb.RateLaptopResponse, error) {
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+
+		for _, req := range reqs {
+			err := stream.Send(req)
+			require.NoError(t, err)
+		}
+		err = stream.CloseSend()
+		require.NoError(t, err)
+
+		var responses []*pb.RateLaptopResponse
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return responses, nil
+			}
+			if err != nil {
+				return responses, err
+			}
+			require.Equal(t, laptop.GetId(), res.GetLaptopId())
+			responses = append(responses, res)
+		}
+	}
+	requireRating := func(res *pb.RateLaptopResponse, count uint32, average float64) {
+		require.Equal(t, count, res.GetRatedCount())
+		require.Equal(t, average, res.GetAverageScore())
+	}
+	kay := userContext(t, jwtManager, "kay", "role1")
+	jay := userContext(t, jwtManager, "jay", "admin")
+
+	// A new score of a user replaces the previous one.
+	scores := []float64{8, 7.5, 10}
+	var reqs []*pb.RateLaptopRequest
+	for _, score := range scores {
+		reqs = append(reqs, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score})
+	}
+	responses, err := rate(kay, reqs...)
+	require.NoError(t, err)
+	require.Len(t, responses, len(scores))
+	for i, res := range responses {
+		requireRating(res, 1, scores[i])
+	}
+
+	responses, err = rate(jay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 6})
+	require.NoError(t, err)
+	requireRating(responses[0], 2, 8)
+
+	// Retracted once.
+	retract := &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Retract: true}
+	responses, err = rate(kay, retract, retract)
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	requireRating(responses[0], 1, 6)
+	require.Equal(t, uint32(codes.NotFound), responses[1].GetError().GetCode())
+
+	responses, err = rate(jay, retract)
+	require.NoError(t, err)
+	requireRating(responses[0], 0, 0)
+	rating, err := ratingStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Nil(t, rating)
+
+	// Only the authenticated users can rate.
+	_, err = rate(context.Background(), reqs...)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	conn = startBufconnLaptopServer(t, laptopStore, nil, ratingStore)
+	stream, err := pb.NewLaptopServiceClient(conn).RateLaptop(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestClientRateLaptopInvalidScores(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	accessibleRoles := map[string][]string{"/aleg.laptops.LaptopService/RateLaptop": {"role1"}}
+	scale := service.RatingScale{Min: 1, Max: 5, Step: 0.5}
+	conn, jwtManager := startAuthLaptopServer(t, laptopStore, nil, ratingStore, accessibleRoles, service.WithRatingScale(scale))
+	stream, err := pb.NewLaptopServiceClient(conn).RateLaptop(userContext(t, jwtManager, "kay", "role1"))
+	require.NoError(t, err)
+
+	// Rejected one by one, without a change of the rating.
+	scores := []float64{math.NaN(), math.Inf(1), -1, 0.5, 5.5, 1e9, 3.2}
+	for _, score := range scores {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score})
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, laptop.GetId(), res.GetLaptopId())
+		require.Equal(t, uint32(codes.InvalidArgument), res.GetError().GetCode(), "score %v", score)
+		require.NotEmpty(t, res.GetError().GetMessage())
+	}
+
+	rating, err := ratingStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Nil(t, rating)
+
+	// The stream goes on with the valid scores.
+	for _, score := range []float64{1, 4.5, 5} {
+		err := stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: score})
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Nil(t, res.GetError())
+		require.Equal(t, uint32(1), res.GetRatedCount())
+		require.Equal(t, score, res.GetAverageScore())
+	}
+
+	// Unknown laptops are rejected the same way.
+	err = stream.Send(&pb.RateLaptopRequest{LaptopId: uuid.New().String(), Score: 3})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint32(codes.NotFound), res.GetError().GetCode())
+
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestClientGetRating(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	laptop := sample.NewLaptop()
+	otherLaptop := sample.NewLaptop()
+	for _, laptop := range []*pb.Laptop{laptop, otherLaptop} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	scores := map[string]float64{"kay": 2, "jay": 4, "rob": 4, "ann": 4, "bob": 5, "eve": 9}
+	for username, score := range scores {
+		_, err := ratingStore.Rate(laptop.GetId(), username, score)
+		require.NoError(t, err)
+	}
+
+	conn := startBufconnLaptopServer(t, laptopStore, nil, ratingStore)
+	laptopClient := client.NewLaptopClient(conn)
+
+	rating, err := laptopClient.GetRating(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), rating.GetLaptopId())
+	require.Equal(t, uint32(6), rating.GetRatedCount())
+	require.Equal(t, 4.666666666666667, rating.GetAverageScore())
+	require.Equal(t, 4.0, rating.GetMedianScore())
+	require.InDelta(t, 2.134375, rating.GetScoreStddev(), 1e-6)
+	expectedHistogram := []*pb.LaptopRating_Bucket{
+		{Score: 2, Count: 1},
+		{Score: 4, Count: 3},
+		{Score: 5, Count: 1},
+		{Score: 9, Count: 1},
+	}
+	require.Len(t, rating.GetHistogram(), len(expectedHistogram))
+	for i, bucket := range rating.GetHistogram() {
+		require.True(t, proto.Equal(expectedHistogram[i], bucket))
+	}
+
+	// Not rated.
+	rating, err = laptopClient.GetRating(otherLaptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), rating.GetRatedCount())
+	require.Empty(t, rating.GetHistogram())
+
+	// Batch, in the order of the IDs.
+	ratings, err := laptopClient.BatchGetRatings([]string{otherLaptop.GetId(), laptop.GetId()})
+	require.NoError(t, err)
+	require.Len(t, ratings, 2)
+	require.Equal(t, otherLaptop.GetId(), ratings[0].GetLaptopId())
+	require.Equal(t, uint32(0), ratings[0].GetRatedCount())
+	require.Equal(t, laptop.GetId(), ratings[1].GetLaptopId())
+	require.Equal(t, uint32(6), ratings[1].GetRatedCount())
+
+	// Invalid requests.
+	laptopService := pb.NewLaptopServiceClient(conn)
+	_, err = laptopService.GetRating(context.Background(), &pb.GetRatingRequest{LaptopId: "invalid"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = laptopService.GetRating(context.Background(), &pb.GetRatingRequest{LaptopId: uuid.New().String()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	laptopIds := []string{laptop.GetId(), uuid.New().String()}
+	_, err = laptopService.BatchGetRatings(context.Background(), &pb.BatchGetRatingsRequest{LaptopIds: laptopIds})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	laptopIds = make([]string, 101)
+	for i := range laptopIds {
+		laptopIds[i] = laptop.GetId()
+	}
+	_, err = laptopService.BatchGetRatings(context.Background(), &pb.BatchGetRatingsRequest{LaptopIds: laptopIds})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestClientTopRatedLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+
+	// One 10/10, many 9.4/10, none, and another brand.
+	laptops := make([]*pb.Laptop, 4)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		laptops[i].Brand = "Apple"
+	}
+	laptops[3].Brand = "Dell"
+	for _, laptop := range laptops {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	rate := func(laptop *pb.Laptop, users int, score float64) {
+		for i := 0; i < users; i++ {
+			_, err := ratingStore.Rate(laptop.GetId(), fmt.Sprintf("user%d", i), score)
+			require.NoError(t, err)
+		}
+	}
+	rate(laptops[0], 1, 10)
+	rate(laptops[1], 500, 9.4)
+	rate(laptops[3], 500, 10)
+
+	prior := service.RatingPrior{Mean: 5, Weight: 10}
+	conn := startBufconnLaptopServer(t, laptopStore, nil, ratingStore, service.WithRatingPrior(prior))
+	laptopClient := client.NewLaptopClient(conn)
+
+	filter := &pb.Filter{Brands: []string{"apple"}}
+	top, err := laptopClient.TopRatedLaptops(filter, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, top, 3)
+	for i, expected := range []*pb.Laptop{laptops[1], laptops[0], laptops[2]} {
+		require.Equal(t, expected.GetId(), top[i].GetLaptop().GetId())
+	}
+	require.Equal(t, uint32(500), top[0].GetRatedCount())
+	require.InDelta(t, 9.4, top[0].GetAverageScore(), 1e-9)
+	require.InDelta(t, (5*10+9.4*500)/510, top[0].GetDampedScore(), 1e-9)
+	require.Equal(t, 10.0, top[1].GetAverageScore())
+	require.InDelta(t, (5*10+10.0)/11, top[1].GetDampedScore(), 1e-9)
+	require.Equal(t, uint32(0), top[2].GetRatedCount())
+	require.Equal(t, 5.0, top[2].GetDampedScore())
+
+	// Limited, and rated enough.
+	top, err = laptopClient.TopRatedLaptops(filter, 1, 0)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	require.Equal(t, laptops[1].GetId(), top[0].GetLaptop().GetId())
+
+	top, err = laptopClient.TopRatedLaptops(filter, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	top, err = laptopClient.TopRatedLaptops(nil, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, top, 2)
+	require.Equal(t, laptops[3].GetId(), top[0].GetLaptop().GetId())
+
+	// Following the new scores.
+	rate(laptops[0], 1000, 10)
+	top, err = laptopClient.TopRatedLaptops(filter, 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, laptops[0].GetId(), top[0].GetLaptop().GetId())
+
+	// At most 100 laptops, whatever the limit.
+	for i := 0; i < 100; i++ {
+		err := laptopStore.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+	res, err := pb.NewLaptopServiceClient(conn).TopRatedLaptops(context.Background(), &pb.TopRatedLaptopsRequest{Limit: 1000})
+	require.NoError(t, err)
+	require.Len(t, res.GetLaptops(), 100)
+}
+
+func TestClientReviews(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+	reviewStore := stores.NewInMemoryReviewStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	accessibleRoles := map[string][]string{
+		"/aleg.laptops.LaptopService/RateLaptop": {"role1", "admin"},
+		"/aleg.laptops.LaptopService/VoteReview": {"role1", "admin"},
+	}
+	conn, jwtManager := startAuthLaptopServer(t, laptopStore, nil, ratingStore, accessibleRoles, service.WithReviewStore(reviewStore))
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	kay := userContext(t, jwtManager, "kay", "role1")
+	jay := userContext(t, jwtManager, "jay", "admin")
+
+	rate := func(ctx context.Context, req *pb.RateLaptopRequest) *pb.RateLaptopResponse {
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(req))
+		require.NoError(t, stream.CloseSend())
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		return res
+	}
+	list := func(orderBy pb.ListReviewsRequest_OrderBy) []*pb.Review {
+		req := &pb.ListReviewsRequest{LaptopId: laptop.GetId(), OrderBy: orderBy}
+		res, err := laptopClient.ListReviews(context.Background(), req)
+		require.NoError(t, err)
+		return res.GetReviews()
+	}
+
+	// Reviews by the authenticated users.
+	res := rate(kay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8, ReviewTitle: "Great", ReviewBody: "Fast and light."})
+	require.Nil(t, res.GetError())
+	kayReviewId := res.GetReviewId()
+	require.NotEmpty(t, kayReviewId)
+	res = rate(jay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 4, ReviewTitle: "Meh"})
+	jayReviewId := res.GetReviewId()
+	require.NotEmpty(t, jayReviewId)
+
+	// A new score without a review keeps the review.
+	res = rate(kay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 9})
+	require.Equal(t, kayReviewId, res.GetReviewId())
+
+	reviews := list(pb.ListReviewsRequest_NEWEST)
+	require.Len(t, reviews, 2)
+	require.Equal(t, jayReviewId, reviews[0].GetId())
+	require.Equal(t, "jay", reviews[0].GetAuthor())
+	require.Equal(t, kayReviewId, reviews[1].GetId())
+	require.Equal(t, "kay", reviews[1].GetAuthor())
+	require.Equal(t, 9.0, reviews[1].GetScore())
+	require.Equal(t, "Great", reviews[1].GetTitle())
+	require.Equal(t, "Fast and light.", reviews[1].GetBody())
+
+	// Helpful votes, by the others.
+	vote, err := laptopClient.VoteReview(jay, &pb.VoteReviewRequest{ReviewId: kayReviewId, Helpful: true})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), vote.GetReview().GetHelpfulVotes())
+	_, err = laptopClient.VoteReview(kay, &pb.VoteReviewRequest{ReviewId: kayReviewId, Helpful: true})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = laptopClient.VoteReview(context.Background(), &pb.VoteReviewRequest{ReviewId: kayReviewId, Helpful: true})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = laptopClient.VoteReview(kay, &pb.VoteReviewRequest{ReviewId: uuid.New().String(), Helpful: true})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	reviews = list(pb.ListReviewsRequest_MOST_HELPFUL)
+	require.Equal(t, kayReviewId, reviews[0].GetId())
+	require.Equal(t, uint32(1), reviews[0].GetHelpfulVotes())
+
+	// Paged.
+	req := &pb.ListReviewsRequest{LaptopId: laptop.GetId(), PageSize: 1}
+	page, err := laptopClient.ListReviews(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, page.GetReviews(), 1)
+	require.NotEmpty(t, page.GetNextPageToken())
+	req.PageToken = page.GetNextPageToken()
+	page, err = laptopClient.ListReviews(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, kayReviewId, page.GetReviews()[0].GetId())
+	require.Empty(t, page.GetNextPageToken())
+
+	req.OrderBy = pb.ListReviewsRequest_MOST_HELPFUL
+	_, err = laptopClient.ListReviews(context.Background(), req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = laptopClient.ListReviews(context.Background(), &pb.ListReviewsRequest{LaptopId: uuid.New().String()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Too long, rejected with the score.
+	res = rate(jay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 1, ReviewTitle: strings.Repeat("x", 201)})
+	require.Equal(t, uint32(codes.InvalidArgument), res.GetError().GetCode())
+	reviews = list(pb.ListReviewsRequest_NEWEST)
+	require.Equal(t, "Meh", reviews[0].GetTitle())
+	require.Equal(t, 4.0, reviews[0].GetScore())
+
+	// Removed with the score.
+	res = rate(jay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Retract: true})
+	require.Nil(t, res.GetError())
+	require.Empty(t, res.GetReviewId())
+	reviews = list(pb.ListReviewsRequest_NEWEST)
+	require.Len(t, reviews, 1)
+	require.Equal(t, kayReviewId, reviews[0].GetId())
+}
+
+// failingReviewStore is a review store failing to save
+// the reviews titled "fail", and to delete the reviews of "zed".
+type failingReviewStore struct {
+	stores.ReviewStore
+}
+
+func (st failingReviewStore) Save(review *pb.Review) (*pb.Review, error) {
+	if review.GetTitle() == "fail" {
+		return nil, errors.New("disk full")
+	}
+	return st.ReviewStore.Save(review)
+}
+
+func (st failingReviewStore) Delete(laptopId string, author string) error {
+	if author == "zed" {
+		return errors.New("disk full")
+	}
+	return st.ReviewStore.Delete(laptopId, author)
+}
+
+func TestClientRateLaptopFailingReviewStore(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	ratingStore := stores.NewInMemoryRatingStore()
+	reviewStore := stores.NewInMemoryReviewStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	accessibleRoles := map[string][]string{"/aleg.laptops.LaptopService/RateLaptop": {"role1", "admin"}}
+	options := service.WithReviewStore(failingReviewStore{reviewStore})
+	conn, jwtManager := startAuthLaptopServer(t, laptopStore, nil, ratingStore, accessibleRoles, options)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	kay := userContext(t, jwtManager, "kay", "role1")
+	zed := userContext(t, jwtManager, "zed", "admin")
+
+	rate := func(ctx context.Context, req *pb.RateLaptopRequest) error {
+		stream, err := laptopClient.RateLaptop(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(req))
+		require.NoError(t, stream.CloseSend())
+		res, err := stream.Recv()
+		if err == nil {
+			require.Nil(t, res.GetError())
+		}
+		return err
+	}
+	requireRating := func(count uint32, sum float64) {
+		rating, err := ratingStore.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.Equal(t, &stores.Rating{Count: count, Sum: sum}, rating)
+	}
+
+	err = rate(kay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8, ReviewTitle: "Great"})
+	require.NoError(t, err)
+	err = rate(zed, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 4, ReviewTitle: "Meh"})
+	require.NoError(t, err)
+	requireRating(2, 12)
+
+	// The score is not saved without its review.
+	err = rate(kay, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 2, ReviewTitle: "fail"})
+	require.Equal(t, codes.Internal, status.Code(err))
+	requireRating(2, 12)
+	review, err := reviewStore.Find(laptop.GetId(), "kay")
+	require.NoError(t, err)
+	require.Equal(t, "Great", review.GetTitle())
+	require.Equal(t, 8.0, review.GetScore())
+
+	// Nor retracted while its review remains.
+	err = rate(zed, &pb.RateLaptopRequest{LaptopId: laptop.GetId(), Retract: true})
+	require.Equal(t, codes.Internal, status.Code(err))
+	requireRating(2, 12)
+	review, err = reviewStore.Find(laptop.GetId(), "zed")
+	require.NoError(t, err)
+	require.Equal(t, "Meh", review.GetTitle())
+}
+
+func startTestLaptopServer(t *testing.T, laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore, options ...service.LaptopServerOption) string {
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore, options...)
+	t.Cleanup(laptopServer.Close)
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0") // random available port
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+// startBufconnLaptopServer starts the laptop server on an in-memory
+// connection and returns a client connection to it.
+func startBufconnLaptopServer(t *testing.T, laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore, options ...service.LaptopServerOption) *grpc.ClientConn {
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore, options...)
+	t.Cleanup(laptopServer.Close)
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	return dialBufconn(t, grpcServer)
+}
+
+// startAuthLaptopServer starts a laptop server authorizing the
+// `accessibleRoles` like the server command, and returns a connection
+// to it and the manager of the access tokens (see `userContext`).
+func startAuthLaptopServer(t *testing.T, laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore, accessibleRoles map[string][]string, options ...service.LaptopServerOption) (*grpc.ClientConn, *users.JWTManager) {
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore, options...)
+	t.Cleanup(laptopServer.Close)
+
+	jwtManager := users.NewJWTManager("secret", time.Minute)
+	authInterceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.StreamInterceptor(authInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	return dialBufconn(t, grpcServer), jwtManager
+}
+
+// userContext returns a context with the access token of a user.
+func userContext(t *testing.T, jwtManager *users.JWTManager, username string, role string) context.Context {
+	token, err := jwtManager.Generate(&users.User{Username: username, Role: role})
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+}
+
+// dialBufconn serves a gRPC server in memory, and returns a connection to it.
+func dialBufconn(t *testing.T, grpcServer *grpc.Server) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// newTestImage returns a PNG image of 3x2 pixels, padded to
+// `size` bytes (the data after the end of a PNG is ignored).
+func newTestImage(t *testing.T, size int) []byte {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, image.NewGray(image.Rect(0, 0, 3, 2)))
+	require.NoError(t, err)
+	require.LessOrEqual(t, buffer.Len(), size)
+
+	imageData := make([]byte, size)
+	copy(imageData, buffer.Bytes())
+	return imageData
+}
+
+// imageFiles returns the names of the files of
+// `imageFolder`, except the journal of the images.
+func imageFiles(t *testing.T, imageFolder string) []string {
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != "images.journal" {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
 }
 
 func newTestLaptopClient(t *testing.T, serverAddress string) pb.LaptopServiceClient {
@@ -1,34 +1,142 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
 
+	"github.com/aleg/go-grpc-laptops/images"
 	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/query"
 	"github.com/aleg/go-grpc-laptops/stores"
+	"github.com/aleg/go-grpc-laptops/users"
 	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// 1MB
-const maxImageSize = 1 << 20
+// Default max size of an uploaded image (32MB).
+const defaultMaxImageSize = 32 << 20
+
+// Default max number of pixels of an uploaded image (50 megapixels):
+// decoded to generate its variants, such an image takes up to 200MB
+// (4 bytes per pixel), for each of the variant workers.
+const defaultMaxImagePixels = 50 << 20
+
+// Size of the chunks of `DownloadImage`.
+const downloadChunkSize = 16 << 10
+
+// Page sizes of `ListLaptops`.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// Max number of laptops of `BatchGetRatings`.
+const maxBatchRatings = 100
 
 type ServerStore struct {
 	laptop stores.LaptopStore
 	image  stores.ImageStore
 	rating stores.RatingStore
+	review stores.ReviewStore // optional (see `WithReviewStore`)
 }
 type LaptopServer struct {
-	store ServerStore
+	store          ServerStore
+	maxImageSize   int64
+	maxImagePixels int
+	uploads        *uploadSessions
+	variantSizes   []int
+	variantWorkers int
+	variants       *variantWorkers
+	stripMetadata  bool
+	ratingScale    RatingScale
+	ratingPrior    RatingPrior
+	quotas         ImageQuotas
+	// Held to commit the uploads when there are quotas, so that
+	// concurrent uploads cannot exceed them together.
+	quotaMutex sync.Mutex
+}
+
+// LaptopServerOption configures a laptop server.
+type LaptopServerOption func(server *LaptopServer)
+
+// WithMaxImageSize sets the max size of an uploaded image (in bytes).
+func WithMaxImageSize(size int64) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImageSize = size
+	}
+}
+
+// WithMaxImagePixels sets the max number of pixels of an uploaded image
+// (width times height), as the images are decoded in memory.
+func WithMaxImagePixels(pixels int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImagePixels = pixels
+	}
+}
+
+// WithImageVariants sets the sizes of the resized variants generated for
+// each uploaded image, i.e. their max width and height in pixels (no
+// variants if empty).
+func WithImageVariants(sizes ...int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.variantSizes = sizes
+	}
 }
 
-func NewLaptopServer(laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore) *LaptopServer {
+// WithVariantWorkers sets the max number of images whose
+// variants are generated at the same time (at least 1).
+func WithVariantWorkers(workers int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.variantWorkers = workers
+	}
+}
+
+// WithStripMetadata sets whether the metadata of the uploaded JPEG and
+// PNG images (EXIF, XMP, ICC profile...) is removed before saving them,
+// like the location of the photos. It is by default.
+func WithStripMetadata(strip bool) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.stripMetadata = strip
+	}
+}
+
+func NewLaptopServer(laptopStore stores.LaptopStore, imageStore stores.ImageStore, ratingStore stores.RatingStore, options ...LaptopServerOption) *LaptopServer {
 	st := ServerStore{laptop: laptopStore, image: imageStore, rating: ratingStore}
-	return &LaptopServer{store: st}
+	server := &LaptopServer{
+		store:          st,
+		maxImageSize:   defaultMaxImageSize,
+		maxImagePixels: defaultMaxImagePixels,
+		uploads:        startUploadSessions(),
+		variantSizes:   defaultVariantSizes,
+		variantWorkers: runtime.NumCPU(),
+		stripMetadata:  true,
+		ratingScale:    defaultRatingScale,
+		ratingPrior:    defaultRatingPrior,
+	}
+	for _, option := range options {
+		option(server)
+	}
+	server.variants = startVariantWorkers(imageStore, server.variantSizes, server.variantWorkers, server.maxImagePixels)
+
+	return server
+}
+
+// Close stops the background work of the server.
+func (server *LaptopServer) Close() {
+	server.uploads.stop()
+	server.variants.stop()
 }
 
 // CreateLaptop is a unary RPC to create a new laptop
@@ -81,10 +189,238 @@ func (server *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLapt
 	return response, nil
 }
 
+// GetLaptop is a unary RPC to get a laptop by its ID
+func (server *LaptopServer) GetLaptop(ctx context.Context, req *pb.GetLaptopRequest) (*pb.GetLaptopResponse, error) {
+	laptopId := req.GetId()
+	log.Printf("Received a get-laptop request with id %s", laptopId)
+
+	// Laptop IDs are always UUIDs, no need to hit
+	// the store for anything else.
+	_, err := uuid.Parse(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	laptop, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot find laptop")
+	}
+	if laptop == nil {
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	response := &pb.GetLaptopResponse{Laptop: laptop}
+	return response, nil
+}
+
+// UpdateLaptop is a unary RPC to update some of the fields of a laptop
+func (server *LaptopServer) UpdateLaptop(ctx context.Context, req *pb.UpdateLaptopRequest) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	laptopId := laptop.GetId()
+	mask := req.GetUpdateMask()
+	log.Printf("Received an update-laptop request with id %s and fields %v", laptopId, mask.GetPaths())
+
+	_, err := uuid.Parse(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+
+	if len(mask.GetPaths()) == 0 {
+		return nil, logError(nil, codes.InvalidArgument, "No fields to update")
+	}
+	if !mask.IsValid(laptop) {
+		msg := fmt.Sprintf("Invalid update mask %v", mask.GetPaths())
+		return nil, logError(nil, codes.InvalidArgument, msg)
+	}
+	mask.Normalize()
+	for _, path := range mask.GetPaths() {
+		// Both fields are managed by the server.
+		if path == "id" || path == "updated_at" {
+			msg := fmt.Sprintf("Field %q cannot be updated", path)
+			return nil, logError(nil, codes.InvalidArgument, msg)
+		}
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	found, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot find laptop")
+	}
+	if found == nil {
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	// The precondition is checked by the store as well, this just
+	// avoids to apply the mask to a laptop that is already outdated.
+	if !proto.Equal(found.GetUpdatedAt(), laptop.GetUpdatedAt()) {
+		msg := fmt.Sprintf("Laptop %s has been updated in the meantime", laptopId)
+		return nil, logError(nil, codes.FailedPrecondition, msg)
+	}
+
+	err = applyFieldMask(found, laptop, mask.GetPaths())
+	if err != nil {
+		return nil, logError(err, codes.InvalidArgument, "Cannot apply the update mask")
+	}
+
+	updated, err := server.store.laptop.Update(found)
+	if err != nil {
+		code := codes.Internal
+		switch {
+		case errors.Is(err, stores.ErrorNotFound):
+			code = codes.NotFound
+		case errors.Is(err, stores.ErrorOutdated):
+			code = codes.FailedPrecondition
+		}
+
+		return nil, logError(err, code, "Cannot update laptop in the store")
+	}
+	log.Printf("Updated laptop with id: %s", laptopId)
+
+	response := &pb.UpdateLaptopResponse{Laptop: updated}
+	return response, nil
+}
+
+// DeleteLaptop is a unary RPC to delete a laptop, together
+// with its images and rating
+func (server *LaptopServer) DeleteLaptop(ctx context.Context, req *pb.DeleteLaptopRequest) (*pb.DeleteLaptopResponse, error) {
+	laptopId := req.GetId()
+	log.Printf("Received a delete-laptop request with id %s", laptopId)
+
+	_, err := uuid.Parse(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// Deleting the laptop first, then what belongs to it. The images
+	// and ratings saved for it in the meantime are deleted by the RPCs
+	// saving them, checking the laptop afterwards (see `laptopDeleted`).
+	err = server.store.laptop.Delete(laptopId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, stores.ErrorNotFound) {
+			code = codes.NotFound
+		}
+
+		return nil, logError(err, code, "Cannot delete laptop from the store")
+	}
+	log.Printf("Deleted laptop with id: %s", laptopId)
+
+	if server.store.image != nil {
+		err = server.store.image.DeleteByLaptop(laptopId)
+		if err != nil {
+			return nil, logError(err, codes.Internal, "Cannot delete the laptop images")
+		}
+	}
+
+	err = server.deleteRatings(laptopId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DeleteLaptopResponse{}, nil
+}
+
+// deleteRatings deletes the rating and the reviews of a laptop.
+func (server *LaptopServer) deleteRatings(laptopId string) error {
+	if server.store.rating != nil {
+		err := server.store.rating.Delete(laptopId)
+		if err != nil {
+			return logError(err, codes.Internal, "Cannot delete the laptop rating")
+		}
+	}
+
+	if server.store.review != nil {
+		err := server.store.review.DeleteByLaptop(laptopId)
+		if err != nil {
+			return logError(err, codes.Internal, "Cannot delete the laptop reviews")
+		}
+	}
+
+	return nil
+}
+
+// laptopDeleted tells whether a laptop has been deleted, once something
+// is saved for it: `DeleteLaptop` may have already deleted what belongs
+// to the laptop, so what was just saved must be deleted too.
+func (server *LaptopServer) laptopDeleted(laptopId string) (bool, error) {
+	laptop, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		return false, logError(err, codes.Internal, "Cannot find laptop")
+	}
+
+	return laptop == nil, nil
+}
+
+// ListLaptops is a unary RPC to list the laptops one page at a time
+func (server *LaptopServer) ListLaptops(ctx context.Context, req *pb.ListLaptopsRequest) (*pb.ListLaptopsResponse, error) {
+	orderBy := req.GetOrderBy()
+	log.Printf("Received a list-laptops request ordered by %v", orderBy)
+
+	if _, ok := pb.ListLaptopsRequest_OrderBy_name[int32(orderBy)]; !ok {
+		msg := fmt.Sprintf("Unknown order %v", orderBy)
+		return nil, logError(nil, codes.InvalidArgument, msg)
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	laptops, next, err := server.store.laptop.List(orderBy, req.GetPageToken(), pageSize)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, stores.ErrorInvalidCursor) {
+			code = codes.InvalidArgument
+		}
+
+		return nil, logError(err, code, "Cannot list laptops")
+	}
+	log.Printf("Listed %d laptops", len(laptops))
+
+	response := &pb.ListLaptopsResponse{Laptops: laptops, NextPageToken: next}
+	return response, nil
+}
+
 // SearchLaptop is a server streaming RPC to search a laptop
 func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := req.GetFilter()
-	log.Printf("Received search-laptop request with filter %v", filter)
+	log.Printf("Received search-laptop request with filter %v and query %q", filter, req.GetQuery())
+
+	if len(req.GetQuery()) > 0 {
+		var err error
+		filter, err = query.Compile(req.GetQuery(), filter)
+		if err != nil {
+			return logError(err, codes.InvalidArgument, "Invalid query")
+		}
+	}
+
+	options, err := server.searchOptions(req)
+	if err != nil {
+		return err
+	}
 
 	// Send the found laptop to the stream.
 	found := func(laptop *pb.Laptop) error {
@@ -98,7 +434,7 @@ func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.
 		return nil
 	}
 
-	err := server.store.laptop.Search(stream.Context(), filter, found)
+	err = server.store.laptop.Search(stream.Context(), filter, options, found)
 	if err != nil {
 		return logError(err, codes.Internal, "Unexpected error")
 	}
@@ -106,6 +442,95 @@ func (server *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.
 	return nil
 }
 
+// WatchLaptops is a server-streaming RPC to watch the changes of the
+// laptops matching a filter. It sends the current matches first, unless
+// the watch is resumed from the resume token of a previous watch.
+func (server *LaptopServer) WatchLaptops(req *pb.WatchLaptopsRequest, stream pb.LaptopService_WatchLaptopsServer) error {
+	filter := req.GetFilter()
+	log.Printf("Received watch-laptops request with filter %v and resume token %q", filter, req.GetResumeToken())
+
+	var revision uint64
+	if token := req.GetResumeToken(); len(token) > 0 {
+		var err error
+		revision, err = strconv.ParseUint(token, 10, 64)
+		if err != nil || revision == 0 {
+			msg := fmt.Sprintf("Invalid resume token %q", token)
+			return logError(nil, codes.InvalidArgument, msg)
+		}
+	}
+
+	// Send each event to the stream.
+	changed := func(event *stores.LaptopEvent) error {
+		res := &pb.WatchLaptopsResponse{Type: event.Type, Laptop: event.Laptop}
+		if event.Revision > 0 {
+			res.ResumeToken = strconv.FormatUint(event.Revision, 10)
+		}
+		return stream.Send(res)
+	}
+
+	err := server.store.laptop.Watch(stream.Context(), filter, revision, changed)
+	if err := contextError(stream.Context()); err != nil {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, stores.ErrorInvalidRevision):
+		return logError(err, codes.InvalidArgument, "Invalid resume token")
+	case errors.Is(err, stores.ErrorRevisionCompacted):
+		// The client has to watch again from the current matches.
+		return logError(err, codes.OutOfRange, "Resume token is too old")
+	default:
+		return logError(err, codes.Internal, "Unexpected error")
+	}
+}
+
+// searchOptions returns the store options to sort
+// and limit the results of a search request.
+func (server *LaptopServer) searchOptions(req *pb.SearchLaptopRequest) (*stores.SearchOptions, error) {
+	options := &stores.SearchOptions{
+		Descending: req.GetDescending(),
+		Offset:     int(req.GetOffset()),
+		Limit:      int(req.GetLimit()),
+	}
+
+	switch sortBy := req.GetSortBy(); sortBy {
+	case pb.SearchLaptopRequest_NONE:
+		// No particular order.
+	case pb.SearchLaptopRequest_RATING:
+		// The laptops never rated last, whatever the direction.
+		unrated := math.Inf(1)
+		if options.Descending {
+			unrated = math.Inf(-1)
+		}
+		options.SortKey = func(laptop *pb.Laptop) float64 {
+			return server.averageScore(laptop, unrated)
+		}
+	default:
+		options.SortKey = stores.LaptopSortKey(sortBy)
+		if options.SortKey == nil {
+			msg := fmt.Sprintf("Cannot sort by %v", sortBy)
+			return nil, logError(nil, codes.InvalidArgument, msg)
+		}
+	}
+
+	return options, nil
+}
+
+// averageScore returns the average score of a laptop
+// (`unrated` if it has never been rated).
+func (server *LaptopServer) averageScore(laptop *pb.Laptop, unrated float64) float64 {
+	if server.store.rating == nil {
+		return unrated
+	}
+
+	rating, err := server.store.rating.Find(laptop.GetId())
+	if err != nil || rating == nil || rating.Count == 0 {
+		return unrated
+	}
+
+	return rating.Sum / float64(rating.Count)
+}
+
 // UploadImage is a client streaming RPC to upload an image in chunks.
 // `stream` is the stream of messages (meta data or chunks of the file)
 // sent from the client.
@@ -116,10 +541,15 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return logError(err, codes.Unknown, "Cannot receive image info request")
 	}
 
-	laptopId := req.GetInfo().GetLaptopId()
-	imageType := req.GetInfo().GetImageType()
+	imageInfo := req.GetInfo()
+	laptopId := imageInfo.GetLaptopId()
+	imageType := imageInfo.GetImageType()
 	log.Printf("Received upload-image request for laptop %s with image type %s", laptopId, imageType)
 
+	if err := server.checkImageInfo(imageInfo); err != nil {
+		return err
+	}
+
 	laptop, err := server.store.laptop.Find(laptopId)
 	if err != nil {
 		return logError(err, codes.Internal, "Cannot find laptop")
@@ -128,9 +558,45 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return logError(nil, codes.InvalidArgument, fmt.Sprintf("Laptop %s doesn't exist", laptopId))
 	}
 
-	// Then, start uploading in chunks.
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	// The quotas are checked upfront with the announced size (if any),
+	// while receiving the chunks and finally before saving the image.
+	var username string
+	if claims := users.FromContext(stream.Context()); claims != nil {
+		username = claims.Username
+	}
+	usage, err := server.imageUsage(laptopId, username)
+	if err != nil {
+		return err
+	}
+	if err := server.quotas.check(usage, int64(imageInfo.GetTotalSize())); err != nil {
+		return err
+	}
+
+	// Then, start (or resume) uploading in chunks, straight to the
+	// store. The upload is discarded if it cannot be resumed anymore.
+	create := func() (stores.ImageUpload, error) {
+		return server.store.image.Create(laptopId, imageType, username)
+	}
+	session, err := server.uploads.acquire(imageInfo, username, create)
+	switch {
+	case errors.Is(err, errorSessionOwner):
+		// Not telling others about the sessions of a user.
+		msg := fmt.Sprintf("Upload session %s doesn't exist", imageInfo.GetSessionId())
+		return logError(err, codes.NotFound, msg)
+	case errors.Is(err, errorSessionMismatch):
+		return logError(err, codes.InvalidArgument, "Cannot resume upload")
+	case errors.Is(err, errorSessionBusy):
+		return logError(err, codes.Aborted, "Cannot resume upload")
+	case err != nil:
+		return logError(err, codes.Internal, "Cannot create image in the store")
+	}
+	done := false
+	defer func() {
+		server.uploads.release(session, done)
+	}()
+	if session.offset > 0 {
+		log.Printf("Resuming upload session %s at offset %d", session.id, session.offset)
+	}
 
 	for {
 		// Checking for errors before receiving more data.
@@ -148,31 +614,99 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 			return logError(err, codes.Unknown, "Cannot receive chunk data")
 		}
 
-		chunk := req.GetChunkData() // getting image data from request
-		size := len(chunk)          // size of the chunk
-		imageSize += size
+		// getting image data from request
+		chunk := req.GetChunkData()
+		if req.GetChunk() != nil {
+			chunk = req.GetChunk().GetData()
+			if offset := req.GetChunk().GetOffset(); offset != session.offset {
+				msg := fmt.Sprintf("Chunk at offset %d, expected at offset %d", offset, session.offset)
+				return logError(nil, codes.FailedPrecondition, msg)
+			}
+		}
+
+		size := len(chunk) // size of the chunk
+		imageSize := session.offset + uint64(size)
 		log.Printf("Chunk received (%d bytes; current total size: %d)", size, imageSize)
 
-		if imageSize > maxImageSize {
-			msg := fmt.Sprintf("Image is too large: %d > %d", imageSize, maxImageSize)
+		if imageSize > uint64(server.maxImageSize) {
+			done = true
+			msg := fmt.Sprintf("Image is too large: %d > %d", imageSize, server.maxImageSize)
+			return logError(nil, codes.InvalidArgument, msg)
+		}
+		if total := imageInfo.GetTotalSize(); total > 0 && imageSize > total {
+			done = true
+			msg := fmt.Sprintf("Image is larger than announced: %d > %d", imageSize, total)
 			return logError(nil, codes.InvalidArgument, msg)
 		}
+		if err := server.quotas.check(usage, int64(imageSize)); err != nil {
+			done = true
+			return err
+		}
+
+		// The type of the image is checked as soon as its
+		// first bytes are received, before writing them.
+		err = session.checkFormat(chunk)
+		if err != nil {
+			done = true
+			return logError(err, codes.InvalidArgument, "Invalid image")
+		}
 
 		// TODO: writing slowly.
 		// time.Sleep(time.Second)
 
-		_, err = imageData.Write(chunk) // writing chunk to the buffer.
+		err = session.write(chunk) // writing chunk to the store.
 		if err != nil {
+			done = true
 			return logError(err, codes.Internal, "Cannot write chunk data")
 		}
+		server.uploads.advance(session, size)
 	}
 
-	// Saving image to file.
-	imageId, err := server.store.image.Save(laptopId, imageType, imageData)
-	if err != nil {
+	imageSize := session.offset
+	if total := imageInfo.GetTotalSize(); total > 0 && imageSize < total {
+		// The session can still be resumed.
+		msg := fmt.Sprintf("Image is incomplete: %d < %d", imageSize, total)
+		return logError(nil, codes.FailedPrecondition, msg)
+	}
+
+	// Saving image to file, only if it's the expected one.
+	done = true
+	if !session.checkDigest() {
+		return logError(nil, codes.DataLoss, "Image doesn't match its SHA-256")
+	}
+
+	info, err := server.commitUpload(session, laptopId, username)
+	if _, ok := status.FromError(err); ok && err != nil {
+		return err // quota exceeded
+	}
+	switch {
+	case errors.Is(err, images.ErrorUnsupported), errors.Is(err, images.ErrorMismatch), errors.Is(err, images.ErrorCorrupt), errors.Is(err, images.ErrorTooLarge):
+		return logError(err, codes.InvalidArgument, "Invalid image")
+	case err != nil:
 		return logError(err, codes.Internal, "Cannot save image to the store (file)")
 	}
-	log.Printf("Image saved with id %s, size %d", imageId, imageSize)
+	imageId := info.Id
+	log.Printf("Image saved with id %s, size %d, %dx%d pixels", imageId, imageSize, info.Width, info.Height)
+
+	deleted, err := server.laptopDeleted(laptopId)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		err = server.store.image.Delete(imageId)
+		if err != nil {
+			return logError(err, codes.Internal, "Cannot delete image")
+		}
+		return logError(nil, codes.InvalidArgument, fmt.Sprintf("Laptop %s doesn't exist", laptopId))
+	}
+
+	if imageInfo.GetPrimary() {
+		err = server.store.image.SetPrimary(imageId)
+		if err != nil {
+			return logError(err, codes.Internal, "Cannot set the primary image")
+		}
+	}
+	server.variants.enqueue(imageId)
 
 	// Generating the response using the image ID just generated.
 	res := &pb.UploadImageResponse{Id: imageId, Size: uint32(imageSize)}
@@ -185,10 +719,334 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 	return nil
 }
 
+// commitUpload saves an uploaded image (without its metadata if stripped),
+// if it doesn't exceed the quotas with the images saved in the meantime.
+func (server *LaptopServer) commitUpload(session *uploadSession, laptopId string, username string) (*stores.ImageInfo, error) {
+	format, _ := images.Sniff(session.header)
+	if server.stripMetadata && (format == images.JPEG || format == images.PNG) {
+		// Not orienting the images with too many pixels, like their variants.
+		err := session.upload.Rewrite(func(w io.Writer, r io.Reader) error {
+			return images.StripMetadata(w, r, server.maxImagePixels)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if server.quotas.limited() {
+		server.quotaMutex.Lock()
+		defer server.quotaMutex.Unlock()
+
+		usage, err := server.imageUsage(laptopId, username)
+		if err != nil {
+			return nil, err
+		}
+		// The size of the image saved, as counted in the usage.
+		if err := server.quotas.check(usage, session.upload.Size()); err != nil {
+			return nil, err
+		}
+	}
+
+	return session.upload.Commit(server.maxImagePixels)
+}
+
+// checkImageInfo checks the info of an image to upload.
+func (server *LaptopServer) checkImageInfo(info *pb.UploadImageRequest_ImageInfo) error {
+	if imageType := info.GetImageType(); len(imageType) > 0 {
+		_, err := images.ParseType(imageType)
+		if err != nil {
+			return logError(err, codes.InvalidArgument, "Invalid image type")
+		}
+	}
+
+	if total := info.GetTotalSize(); total > uint64(server.maxImageSize) {
+		msg := fmt.Sprintf("Image is too large: %d > %d", total, server.maxImageSize)
+		return logError(nil, codes.InvalidArgument, msg)
+	}
+
+	if digest := info.GetSha256(); len(digest) > 0 && len(digest) != sha256.Size {
+		msg := fmt.Sprintf("Invalid SHA-256 of %d bytes", len(digest))
+		return logError(nil, codes.InvalidArgument, msg)
+	}
+
+	// A session can be resumed only if the server knows
+	// when the image is complete, and that it's the right one.
+	if len(info.GetSessionId()) > 0 && (info.GetTotalSize() == 0 || len(info.GetSha256()) == 0) {
+		return logError(nil, codes.InvalidArgument, "Upload sessions need the total size and the SHA-256 of the image")
+	}
+
+	return nil
+}
+
+// QueryUpload is a unary RPC to know where to resume an upload from.
+func (server *LaptopServer) QueryUpload(ctx context.Context, req *pb.QueryUploadRequest) (*pb.QueryUploadResponse, error) {
+	sessionId := req.GetSessionId()
+	log.Printf("Received a query-upload request for session %s", sessionId)
+
+	var username string
+	if claims := users.FromContext(ctx); claims != nil {
+		username = claims.Username
+	}
+	offset, total, found := server.uploads.find(sessionId, username)
+	if !found {
+		msg := fmt.Sprintf("Upload session %s doesn't exist", sessionId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	return &pb.QueryUploadResponse{CommittedOffset: offset, TotalSize: total}, nil
+}
+
+// DownloadImage is a server-streaming RPC to download an image
+// in chunks: the image info first, then the image data.
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageId := req.GetImageId()
+	variant := int(req.GetVariant())
+	log.Printf("Received download-image request for image %s (variant %d)", imageId, variant)
+
+	info, err := server.store.image.Find(imageId)
+	if err != nil {
+		return logError(err, codes.Internal, "Cannot find image")
+	}
+	if info == nil {
+		return logError(nil, codes.NotFound, fmt.Sprintf("Image %s doesn't exist", imageId))
+	}
+
+	imageType, size, width, height := info.Type, info.Size, info.Width, info.Height
+	if variant > 0 {
+		imageVariant, found := info.Variants[variant]
+		if !found {
+			msg := fmt.Sprintf("Image %s has no variant %d (yet)", imageId, variant)
+			return logError(nil, codes.NotFound, msg)
+		}
+		imageType, size, width, height = imageVariant.Type, imageVariant.Size, imageVariant.Width, imageVariant.Height
+	}
+
+	image, err := server.store.image.Open(imageId, variant)
+	if errors.Is(err, stores.ErrorNotFound) {
+		// Deleted in the meantime.
+		return logError(nil, codes.NotFound, fmt.Sprintf("Image %s doesn't exist", imageId))
+	}
+	if err != nil {
+		return logError(err, codes.Internal, "Cannot open image")
+	}
+	defer image.Close()
+
+	// First, send the image info.
+	infoRes := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: &pb.DownloadImageResponse_ImageInfo{
+				LaptopId:  info.LaptopId,
+				ImageType: imageType,
+				Size:      uint64(size),
+				Width:     uint32(width),
+				Height:    uint32(height),
+			},
+		},
+	}
+	err = stream.Send(infoRes)
+	if err != nil {
+		return logError(err, codes.Unknown, "Cannot send image info")
+	}
+
+	// Then, the image in chunks.
+	buffer := make([]byte, downloadChunkSize)
+	imageSize := 0
+	for {
+		// Checking for errors before sending more data.
+		if err := contextError(stream.Context()); err != nil {
+			return err
+		}
+
+		n, err := image.Read(buffer)
+		if n > 0 {
+			chunkRes := &pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			}
+			if err := stream.Send(chunkRes); err != nil {
+				return logError(err, codes.Unknown, "Cannot send chunk data")
+			}
+			imageSize += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(err, codes.Internal, "Cannot read image")
+		}
+	}
+
+	log.Printf("Image %s sent, size %d", imageId, imageSize)
+	return nil
+}
+
+// ListLaptopImages is a unary RPC to list the images of a laptop.
+func (server *LaptopServer) ListLaptopImages(ctx context.Context, req *pb.ListLaptopImagesRequest) (*pb.ListLaptopImagesResponse, error) {
+	laptopId := req.GetLaptopId()
+	log.Printf("Received a list-laptop-images request for laptop %s", laptopId)
+
+	_, err := uuid.Parse(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	laptop, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot find laptop")
+	}
+	if laptop == nil {
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	laptopImages, err := server.store.image.List(laptopId)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot list the laptop images")
+	}
+
+	response := &pb.ListLaptopImagesResponse{}
+	for _, info := range laptopImages {
+		image := &pb.ListLaptopImagesResponse_Image{
+			Id:         info.Id,
+			ImageType:  info.Type,
+			Size:       uint64(info.Size),
+			UploadedAt: timestamppb.New(info.UploadedAt),
+			Primary:    info.Primary,
+			Width:      uint32(info.Width),
+			Height:     uint32(info.Height),
+		}
+		for size := range info.Variants {
+			image.Variants = append(image.Variants, uint32(size))
+		}
+		sort.Slice(image.Variants, func(i, j int) bool { return image.Variants[i] < image.Variants[j] })
+		response.Images = append(response.Images, image)
+	}
+
+	return response, nil
+}
+
+// DeleteImage is a unary RPC to delete an image.
+func (server *LaptopServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	imageId := req.GetImageId()
+	log.Printf("Received a delete-image request with id %s", imageId)
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err := server.store.image.Delete(imageId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, stores.ErrorNotFound) {
+			code = codes.NotFound
+		}
+
+		return nil, logError(err, code, "Cannot delete image from the store")
+	}
+	log.Printf("Deleted image with id: %s", imageId)
+
+	return &pb.DeleteImageResponse{}, nil
+}
+
+// SetPrimaryImage is a unary RPC to make an image the primary image
+// of its laptop (the one uploaded as primary otherwise).
+func (server *LaptopServer) SetPrimaryImage(ctx context.Context, req *pb.SetPrimaryImageRequest) (*pb.SetPrimaryImageResponse, error) {
+	imageId := req.GetImageId()
+	log.Printf("Received a set-primary-image request with id %s", imageId)
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err := server.store.image.SetPrimary(imageId)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, stores.ErrorNotFound) {
+			code = codes.NotFound
+		}
+
+		return nil, logError(err, code, "Cannot set the primary image")
+	}
+	log.Printf("Set primary image with id: %s", imageId)
+
+	return &pb.SetPrimaryImageResponse{}, nil
+}
+
+// GetImageUsage is a unary RPC to get the usage of the image quotas
+// by a laptop and/or by a user.
+func (server *LaptopServer) GetImageUsage(ctx context.Context, req *pb.GetImageUsageRequest) (*pb.GetImageUsageResponse, error) {
+	laptopId := req.GetLaptopId()
+	username := req.GetUsername()
+	log.Printf("Received a get-image-usage request for laptop %q and user %q", laptopId, username)
+
+	if len(laptopId) == 0 && len(username) == 0 {
+		return nil, logError(nil, codes.InvalidArgument, "Neither a laptop ID nor a username")
+	}
+	if len(laptopId) > 0 {
+		_, err := uuid.Parse(laptopId)
+		if err != nil {
+			msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+			return nil, logError(err, codes.InvalidArgument, msg)
+		}
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	if len(laptopId) > 0 {
+		laptop, err := server.store.laptop.Find(laptopId)
+		if err != nil {
+			return nil, logError(err, codes.Internal, "Cannot find laptop")
+		}
+		if laptop == nil {
+			msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+			return nil, logError(nil, codes.NotFound, msg)
+		}
+	}
+
+	usage, err := server.imageUsage(laptopId, username)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.GetImageUsageResponse{}
+	if len(laptopId) > 0 {
+		response.Laptop = &pb.GetImageUsageResponse_Usage{
+			Images:    uint32(usage.laptop.Images),
+			Bytes:     uint64(usage.laptop.Bytes),
+			MaxImages: uint32(server.quotas.MaxImagesPerLaptop),
+			MaxBytes:  uint64(server.quotas.MaxBytesPerLaptop),
+		}
+	}
+	if len(username) > 0 {
+		response.User = &pb.GetImageUsageResponse_Usage{
+			Images:   uint32(usage.user.Images),
+			Bytes:    uint64(usage.user.Bytes),
+			MaxBytes: uint64(server.quotas.MaxBytesPerUser),
+		}
+	}
+
+	return response, nil
+}
+
 // RateLaptop is a bidirectional-streaming RPC that allows clients to rate
 // a stream of laptops with a score, and returns a stream of avg scores
 // for each of them.
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	// The scores are the ones of the authenticated user.
+	claims := users.FromContext(stream.Context())
+	if claims == nil || len(claims.Username) == 0 {
+		return logError(nil, codes.Unauthenticated, "Rating a laptop needs an authenticated user")
+	}
+	username := claims.Username
+
 	// The client will send a stream of ratings, hence a
 	// while loop is required.
 	for {
@@ -208,40 +1066,230 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 			return logError(err, codes.Unknown, "Cannot receive request from stream")
 		}
 
-		// Extracting the data from the received request.
-		laptopId := req.GetLaptopId()
-		score := req.GetScore()
-		log.Printf("Received a rate-laptop request: id = %s; score = %.2f", laptopId, score)
+		// Rejecting the invalid requests only, going on with the next ones.
+		res, err := server.rateLaptop(req, username)
+		if st, ok := status.FromError(err); ok && isRejection(st.Code()) {
+			res = &pb.RateLaptopResponse{
+				LaptopId: req.GetLaptopId(),
+				Error: &pb.RateLaptopResponse_Rejection{
+					Code:    uint32(st.Code()),
+					Message: st.Message(),
+				},
+			}
+		} else if err != nil {
+			return err
+		}
 
-		// Searhing the laptop by its ID sent with the request.
-		found, err := server.store.laptop.Find(laptopId)
+		// Sending the response to the client stream.
+		err = stream.Send(res)
 		if err != nil {
-			msg := fmt.Sprintf("Cannot find laptop with ID %s", laptopId)
-			return logError(err, codes.Internal, msg)
+			return logError(err, codes.Unknown, "Cannot send the stream response to the clinet")
 		}
-		if found == nil {
-			msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
-			return logError(nil, codes.NotFound, msg)
+		log.Printf("Sent rate-laptop response: id = %s; average score = %.2f", res.GetLaptopId(), res.GetAverageScore())
+	}
+
+	return nil
+}
+
+// rateLaptop saves the score of a laptop by a user (or removes
+// it), and returns the response with the rating of the laptop.
+func (server *LaptopServer) rateLaptop(req *pb.RateLaptopRequest, username string) (*pb.RateLaptopResponse, error) {
+	// Extracting the data from the received request.
+	laptopId := req.GetLaptopId()
+	score := req.GetScore()
+	if req.GetRetract() {
+		log.Printf("Received a rate-laptop request: id = %s; user = %s; retracted", laptopId, username)
+	} else {
+		log.Printf("Received a rate-laptop request: id = %s; user = %s; score = %.2f", laptopId, username, score)
+
+		err := server.ratingScale.check(score)
+		if err != nil {
+			return nil, logError(err, codes.InvalidArgument, "Invalid score")
 		}
+		err = server.checkReview(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Searhing the laptop by its ID sent with the request.
+	found, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("Cannot find laptop with ID %s", laptopId)
+		return nil, logError(err, codes.Internal, msg)
+	}
+	if found == nil {
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
 
-		// Saving the rating to the store.
-		rating, err := server.store.rating.Add(laptopId, score)
+	// Saving the score of the user to the store (or removing it), with
+	// the review attached to it: the previous review is restored if the
+	// new score cannot be saved, and the score if the review cannot be
+	// removed (the score of a review is the one of its author).
+	previous, err := server.findReview(laptopId, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var rating *stores.Rating
+	var reviewId string
+	if req.GetRetract() {
+		rating, err = server.store.rating.Retract(laptopId, username)
+		if errors.Is(err, stores.ErrorNotFound) {
+			msg := fmt.Sprintf("Laptop with ID %s is not rated by user %s", laptopId, username)
+			return nil, logError(nil, codes.NotFound, msg)
+		}
 		if err != nil {
-			return logError(err, codes.Internal, "Cannot add rating to the store")
+			return nil, logError(err, codes.Internal, "Cannot save rating to the store")
 		}
 
-		// Building the response and sending it to the client stream.
-		res := &pb.RateLaptopResponse{
-			LaptopId:     laptopId,
-			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+		err = server.deleteReview(previous)
+		if err != nil {
+			_, rateErr := server.store.rating.Rate(laptopId, username, previous.GetScore())
+			if rateErr != nil {
+				log.Printf("Cannot restore the score of laptop %s by user %s: %v", laptopId, username, rateErr)
+			}
+			return nil, err
 		}
-		err = stream.Send(res)
+	} else {
+		reviewId, err = server.saveReview(req, username, previous)
 		if err != nil {
-			return logError(err, codes.Unknown, "Cannot send the stream response to the clinet")
+			return nil, err
+		}
+
+		rating, err = server.store.rating.Rate(laptopId, username, score)
+		if err != nil {
+			server.restoreReview(laptopId, username, previous)
+			return nil, logError(err, codes.Internal, "Cannot save rating to the store")
 		}
-		log.Printf("Sent rate-laptop response: id = %s; score = %.2f", laptopId, score)
 	}
 
-	return nil
+	deleted, err := server.laptopDeleted(laptopId)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		err = server.deleteRatings(laptopId)
+		if err != nil {
+			return nil, err
+		}
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	// Building the response.
+	res := &pb.RateLaptopResponse{
+		LaptopId:   laptopId,
+		RatedCount: rating.Count,
+		ReviewId:   reviewId,
+	}
+	if rating.Count > 0 {
+		res.AverageScore = rating.Sum / float64(rating.Count)
+	}
+	return res, nil
+}
+
+// GetRating is a unary RPC to get the rating of a laptop,
+// with the distribution of its scores.
+func (server *LaptopServer) GetRating(ctx context.Context, req *pb.GetRatingRequest) (*pb.GetRatingResponse, error) {
+	laptopId := req.GetLaptopId()
+	log.Printf("Received a get-rating request with id %s", laptopId)
+
+	ratings, err := server.laptopRatings(ctx, []string{laptopId})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.GetRatingResponse{Rating: ratings[0]}
+	return response, nil
+}
+
+// BatchGetRatings is a unary RPC to get the ratings of many laptops
+// at once, all read at the same time.
+func (server *LaptopServer) BatchGetRatings(ctx context.Context, req *pb.BatchGetRatingsRequest) (*pb.BatchGetRatingsResponse, error) {
+	laptopIds := req.GetLaptopIds()
+	log.Printf("Received a batch-get-ratings request with %d ids", len(laptopIds))
+
+	if len(laptopIds) > maxBatchRatings {
+		msg := fmt.Sprintf("Too many laptop IDs: %d (max: %d)", len(laptopIds), maxBatchRatings)
+		return nil, logError(nil, codes.InvalidArgument, msg)
+	}
+
+	ratings, err := server.laptopRatings(ctx, laptopIds)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.BatchGetRatingsResponse{Ratings: ratings}
+	return response, nil
+}
+
+// laptopRatings returns the ratings of the laptops, in the same
+// order. All the laptops must exist (even if not rated).
+func (server *LaptopServer) laptopRatings(ctx context.Context, laptopIds []string) ([]*pb.LaptopRating, error) {
+	for _, laptopId := range laptopIds {
+		_, err := uuid.Parse(laptopId)
+		if err != nil {
+			msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+			return nil, logError(err, codes.InvalidArgument, msg)
+		}
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, laptopId := range laptopIds {
+		laptop, err := server.store.laptop.Find(laptopId)
+		if err != nil {
+			return nil, logError(err, codes.Internal, "Cannot find laptop")
+		}
+		if laptop == nil {
+			msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+			return nil, logError(nil, codes.NotFound, msg)
+		}
+	}
+
+	stats := make(map[string]*stores.RatingStats)
+	if server.store.rating != nil {
+		var err error
+		stats, err = server.store.rating.Stats(laptopIds...)
+		if err != nil {
+			return nil, logError(err, codes.Internal, "Cannot get the ratings")
+		}
+	}
+
+	ratings := make([]*pb.LaptopRating, 0, len(laptopIds))
+	for _, laptopId := range laptopIds {
+		ratings = append(ratings, toPbLaptopRating(laptopId, stats[laptopId]))
+	}
+	return ratings, nil
+}
+
+// toPbLaptopRating converts the aggregates of the scores of a
+// laptop to the rating sent to clients (all zeros if not rated).
+func toPbLaptopRating(laptopId string, stats *stores.RatingStats) *pb.LaptopRating {
+	rating := &pb.LaptopRating{LaptopId: laptopId}
+	if stats == nil {
+		return rating
+	}
+
+	rating.RatedCount = stats.Count
+	rating.AverageScore = stats.Mean
+	rating.MedianScore = stats.Median
+	rating.ScoreStddev = stats.StdDev
+	for _, bucket := range stats.Histogram {
+		rating.Histogram = append(rating.Histogram, &pb.LaptopRating_Bucket{
+			Score: bucket.Score,
+			Count: bucket.Count,
+		})
+	}
+	return rating
+}
+
+// isRejection tells whether a rate-laptop request failing
+// with `code` is rejected alone, rather than the whole stream.
+func isRejection(code codes.Code) bool {
+	return code == codes.InvalidArgument || code == codes.NotFound || code == codes.Unimplemented
 }
@@ -2,15 +2,20 @@ package service_test
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/aleg/go-grpc-laptops/pb"
 	"github.com/aleg/go-grpc-laptops/sample"
 	"github.com/aleg/go-grpc-laptops/service"
 	"github.com/aleg/go-grpc-laptops/stores"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func TestServerCreateLaptop(t *testing.T) {
@@ -88,3 +93,196 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerUpdateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	stored, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+
+	outdated := proto.Clone(stored).(*pb.Laptop)
+	outdated.UpdatedAt = laptop.GetUpdatedAt()
+
+	unknown := sample.NewLaptop()
+
+	invalidID := proto.Clone(stored).(*pb.Laptop)
+	invalidID.Id = "invalid-uuid"
+
+	testCases := []struct {
+		name   string
+		laptop *pb.Laptop
+		paths  []string
+		code   codes.Code
+	}{
+		{
+			name:   "success_nested_field",
+			laptop: stored,
+			paths:  []string{"cpu.min_ghz", "screen"},
+			code:   codes.OK,
+		},
+		{
+			name:   "failure_outdated",
+			laptop: outdated,
+			paths:  []string{"price_usd"},
+			code:   codes.FailedPrecondition,
+		},
+		{
+			name:   "failure_not_found",
+			laptop: unknown,
+			paths:  []string{"price_usd"},
+			code:   codes.NotFound,
+		},
+		{
+			name:   "failure_invalid_id",
+			laptop: invalidID,
+			paths:  []string{"price_usd"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_no_paths",
+			laptop: stored,
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_unknown_path",
+			laptop: stored,
+			paths:  []string{"cpu.unknown"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_server_field",
+			laptop: stored,
+			paths:  []string{"updated_at"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	// Not in parallel: the first test case changes `updated_at`.
+	server := service.NewLaptopServer(laptopStore, nil, nil)
+	for _, tc := range testCases {
+		req := &pb.UpdateLaptopRequest{
+			Laptop:     tc.laptop,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: tc.paths},
+		}
+
+		res, err := server.UpdateLaptop(context.Background(), req)
+		if tc.code == codes.OK {
+			require.NoError(t, err, tc.name)
+			require.NotNil(t, res, tc.name)
+			require.Equal(t, tc.laptop.GetCpu().GetMinGhz(), res.GetLaptop().GetCpu().GetMinGhz(), tc.name)
+			require.True(t, proto.Equal(tc.laptop.GetScreen(), res.GetLaptop().GetScreen()), tc.name)
+		} else {
+			require.Error(t, err, tc.name)
+			require.Nil(t, res, tc.name)
+			st, ok := status.FromError(err)
+			require.True(t, ok, tc.name)
+			require.Equal(t, tc.code, st.Code(), tc.name)
+		}
+	}
+}
+
+// stalledSearchStream is a search stream whose client
+// doesn't receive anything until `resume` is closed.
+type stalledSearchStream struct {
+	grpc.ServerStream
+	sent   chan *pb.Laptop
+	resume chan struct{}
+}
+
+func (stream *stalledSearchStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *stalledSearchStream) Send(res *pb.SearchLaptopResponse) error {
+	stream.sent <- res.GetLaptop()
+	<-stream.resume
+	return nil
+}
+
+func TestServerSearchLaptopStalledStream(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := stores.NewInMemoryLaptopStore()
+	for i := 0; i < 3; i++ {
+		err := laptopStore.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	server := service.NewLaptopServer(laptopStore, nil, nil)
+	stream := &stalledSearchStream{
+		sent:   make(chan *pb.Laptop, 3),
+		resume: make(chan struct{}),
+	}
+
+	searchDone := make(chan error)
+	go func() {
+		searchDone <- server.SearchLaptop(&pb.SearchLaptopRequest{}, stream)
+	}()
+
+	// Waiting for the search to be stuck on the first laptop.
+	select {
+	case <-stream.sent:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no laptop sent")
+	}
+
+	// Writers don't wait for the stalled search.
+	created := make(chan error)
+	go func() {
+		req := &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}
+		_, err := server.CreateLaptop(context.Background(), req)
+		created <- err
+	}()
+
+	select {
+	case err := <-created:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "create blocked by a stalled search")
+	}
+
+	// The search goes on with its snapshot (without the new laptop).
+	close(stream.resume)
+	require.NoError(t, <-searchDone)
+	require.Len(t, stream.sent, 2)
+}
+
+func TestRatingPriorCheck(t *testing.T) {
+	t.Parallel()
+
+	scale := service.RatingScale{Min: 1, Max: 10}
+	testCases := []struct {
+		name  string
+		prior service.RatingPrior
+		valid bool
+	}{
+		{"middle", service.RatingPrior{Mean: 5.5, Weight: 10}, true},
+		{"min", service.RatingPrior{Mean: 1, Weight: 0.5}, true},
+		{"max", service.RatingPrior{Mean: 10, Weight: 1}, true},
+		{"zero_weight", service.RatingPrior{Mean: 5.5, Weight: 0}, false},
+		{"negative_weight", service.RatingPrior{Mean: 5.5, Weight: -10}, false},
+		{"infinite_weight", service.RatingPrior{Mean: 5.5, Weight: math.Inf(1)}, false},
+		{"below_scale", service.RatingPrior{Mean: 0.5, Weight: 10}, false},
+		{"above_scale", service.RatingPrior{Mean: 11, Weight: 10}, false},
+		{"nan_mean", service.RatingPrior{Mean: math.NaN(), Weight: 10}, false},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.prior.Check(scale)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
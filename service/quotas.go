@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aleg/go-grpc-laptops/stores"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ImageQuotas limits the images uploaded to the server (0 for no limit).
+// The sizes are the ones of the uploaded images, without their variants.
+type ImageQuotas struct {
+	MaxImagesPerLaptop int
+	MaxBytesPerLaptop  int64
+	// Of the images uploaded by an authenticated user, to any laptop.
+	MaxBytesPerUser int64
+}
+
+// WithImageQuotas sets the quotas of the uploaded images.
+func WithImageQuotas(quotas ImageQuotas) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.quotas = quotas
+	}
+}
+
+// limited reports whether there is any quota.
+func (quotas ImageQuotas) limited() bool {
+	return quotas.MaxImagesPerLaptop > 0 || quotas.MaxBytesPerLaptop > 0 || quotas.MaxBytesPerUser > 0
+}
+
+// imageUsage is the usage of the quotas by the images
+// of a laptop, and by the images of a user.
+type imageUsage struct {
+	laptopId string
+	username string // empty if not authenticated
+	laptop   stores.ImageUsage
+	user     stores.ImageUsage
+}
+
+func (server *LaptopServer) imageUsage(laptopId string, username string) (*imageUsage, error) {
+	laptop, user, err := server.store.image.Usage(laptopId, username)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot get the usage of the images")
+	}
+
+	usage := &imageUsage{
+		laptopId: laptopId,
+		username: username,
+		laptop:   laptop,
+		user:     user,
+	}
+	return usage, nil
+}
+
+// check returns a `ResourceExhausted` error, with the details of the
+// quotas exceeded (a `QuotaFailure`), if adding an image of `size` bytes
+// to the usage exceeds any quota.
+func (quotas ImageQuotas) check(usage *imageUsage, size int64) error {
+	var violations []*errdetails.QuotaFailure_Violation
+	violate := func(subject string, quota string, limit int64, used int64, requested int64) {
+		violations = append(violations, &errdetails.QuotaFailure_Violation{
+			Subject:     subject,
+			Description: fmt.Sprintf("%s: %d (used: %d, requested: %d)", quota, limit, used, requested),
+		})
+	}
+
+	laptopSubject := "laptop:" + usage.laptopId
+	if max := quotas.MaxImagesPerLaptop; max > 0 && usage.laptop.Images+1 > max {
+		violate(laptopSubject, "Max images per laptop", int64(max), int64(usage.laptop.Images), 1)
+	}
+	if max := quotas.MaxBytesPerLaptop; max > 0 && usage.laptop.Bytes+size > max {
+		violate(laptopSubject, "Max bytes per laptop", max, usage.laptop.Bytes, size)
+	}
+	if max := quotas.MaxBytesPerUser; max > 0 && len(usage.username) > 0 && usage.user.Bytes+size > max {
+		violate("user:"+usage.username, "Max bytes per user", max, usage.user.Bytes, size)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	msg := "Image quota exceeded"
+	log.Printf("%s: %v", msg, violations)
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.QuotaFailure{Violations: violations})
+	if err != nil {
+		return logError(err, codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"math"
+)
+
+// Tolerance of the rounding errors of the scores on a step.
+const scoreStepTolerance = 1e-9
+
+// RatingScale is the range of the valid scores of the laptops.
+type RatingScale struct {
+	Min float64
+	Max float64
+	// Difference between two consecutive scores from `Min`,
+	// e.g. 0.5 for half steps (0 for any score in the range).
+	Step float64
+}
+
+// Default scale of the scores: from 1 to 10.
+var defaultRatingScale = RatingScale{Min: 1, Max: 10}
+
+// WithRatingScale sets the scale of the scores of the laptops.
+func WithRatingScale(scale RatingScale) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.ratingScale = scale
+	}
+}
+
+// check returns an error if `score` is not on the scale.
+func (scale RatingScale) check(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("Score %v is not a number", score)
+	}
+	if score < scale.Min || score > scale.Max {
+		return fmt.Errorf("Score %v is not between %v and %v", score, scale.Min, scale.Max)
+	}
+	if scale.Step > 0 {
+		steps := (score - scale.Min) / scale.Step
+		if math.Abs(steps-math.Round(steps)) > scoreStepTolerance {
+			return fmt.Errorf("Score %v is not a multiple of %v from %v", score, scale.Step, scale.Min)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/stores"
+	"github.com/aleg/go-grpc-laptops/users"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+// Max lengths of the reviews (in characters).
+const (
+	maxReviewTitle = 200
+	maxReviewBody  = 10000
+)
+
+// Page sizes of `ListReviews`.
+const (
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+// WithReviewStore sets the store of the reviews attached to the
+// scores (the reviews are not supported without it).
+func WithReviewStore(reviewStore stores.ReviewStore) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.store.review = reviewStore
+	}
+}
+
+// checkReview returns an error if the review of a rate-laptop request
+// cannot be saved.
+func (server *LaptopServer) checkReview(req *pb.RateLaptopRequest) error {
+	title, body := req.GetReviewTitle(), req.GetReviewBody()
+	if len(title) == 0 && len(body) == 0 {
+		return nil
+	}
+
+	if server.store.review == nil {
+		return logError(nil, codes.Unimplemented, "Reviews are not supported")
+	}
+	if n := utf8.RuneCountInString(title); n > maxReviewTitle {
+		msg := fmt.Sprintf("Review title is too long: %d characters (max: %d)", n, maxReviewTitle)
+		return logError(nil, codes.InvalidArgument, msg)
+	}
+	if n := utf8.RuneCountInString(body); n > maxReviewBody {
+		msg := fmt.Sprintf("Review body is too long: %d characters (max: %d)", n, maxReviewBody)
+		return logError(nil, codes.InvalidArgument, msg)
+	}
+
+	return nil
+}
+
+// findReview finds the review of a laptop by a user (nil if there
+// is none, or if the reviews are not supported).
+func (server *LaptopServer) findReview(laptopId string, username string) (*pb.Review, error) {
+	if server.store.review == nil {
+		return nil, nil
+	}
+
+	review, err := server.store.review.Find(laptopId, username)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot find review")
+	}
+
+	return review, nil
+}
+
+// saveReview saves the review of a rate-laptop request before its
+// score, and returns the ID of the review of the user (empty if none).
+// Without a new review, the `previous` one of the user is kept with
+// the new score.
+func (server *LaptopServer) saveReview(req *pb.RateLaptopRequest, username string, previous *pb.Review) (string, error) {
+	if server.store.review == nil {
+		return "", nil
+	}
+
+	review := &pb.Review{
+		LaptopId: req.GetLaptopId(),
+		Author:   username,
+		Score:    req.GetScore(),
+		Title:    req.GetReviewTitle(),
+		Body:     req.GetReviewBody(),
+	}
+	if len(review.Title) == 0 && len(review.Body) == 0 {
+		if previous == nil {
+			return "", nil
+		}
+		review.Title = previous.GetTitle()
+		review.Body = previous.GetBody()
+	}
+
+	saved, err := server.store.review.Save(review)
+	if err != nil {
+		return "", logError(err, codes.Internal, "Cannot save review to the store")
+	}
+
+	return saved.GetId(), nil
+}
+
+// restoreReview restores the `previous` review of a user (or deletes
+// the new one if there was none) when the score cannot be saved.
+func (server *LaptopServer) restoreReview(laptopId string, username string, previous *pb.Review) {
+	if server.store.review == nil {
+		return
+	}
+
+	var err error
+	if previous != nil {
+		_, err = server.store.review.Save(previous)
+	} else {
+		err = server.store.review.Delete(laptopId, username)
+	}
+	if err != nil {
+		log.Printf("Cannot restore the review of laptop %s by user %s: %v", laptopId, username, err)
+	}
+}
+
+// deleteReview deletes the `previous` review of a user (if any), once
+// their score is removed.
+func (server *LaptopServer) deleteReview(previous *pb.Review) error {
+	if previous == nil {
+		return nil
+	}
+
+	err := server.store.review.Delete(previous.GetLaptopId(), previous.GetAuthor())
+	if err != nil {
+		return logError(err, codes.Internal, "Cannot delete review from the store")
+	}
+
+	return nil
+}
+
+// ListReviews is a unary RPC to list the reviews of a laptop, in pages.
+func (server *LaptopServer) ListReviews(ctx context.Context, req *pb.ListReviewsRequest) (*pb.ListReviewsResponse, error) {
+	laptopId := req.GetLaptopId()
+	orderBy := req.GetOrderBy()
+	log.Printf("Received a list-reviews request for laptop %s ordered by %v", laptopId, orderBy)
+
+	_, err := uuid.Parse(laptopId)
+	if err != nil {
+		msg := fmt.Sprintf("The laptop ID %q is not a valid UUID", laptopId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+	if _, ok := pb.ListReviewsRequest_OrderBy_name[int32(orderBy)]; !ok {
+		msg := fmt.Sprintf("Unknown order %v", orderBy)
+		return nil, logError(nil, codes.InvalidArgument, msg)
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultReviewPageSize
+	}
+	if pageSize > maxReviewPageSize {
+		pageSize = maxReviewPageSize
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	laptop, err := server.store.laptop.Find(laptopId)
+	if err != nil {
+		return nil, logError(err, codes.Internal, "Cannot find laptop")
+	}
+	if laptop == nil {
+		msg := fmt.Sprintf("Laptop with ID %s doesn't exist", laptopId)
+		return nil, logError(nil, codes.NotFound, msg)
+	}
+
+	response := &pb.ListReviewsResponse{}
+	if server.store.review == nil {
+		return response, nil
+	}
+
+	response.Reviews, response.NextPageToken, err = server.store.review.List(laptopId, orderBy, req.GetPageToken(), pageSize)
+	if err != nil {
+		code := codes.Internal
+		if errors.Is(err, stores.ErrorInvalidCursor) {
+			code = codes.InvalidArgument
+		}
+
+		return nil, logError(err, code, "Cannot list reviews")
+	}
+	log.Printf("Listed %d reviews", len(response.Reviews))
+
+	return response, nil
+}
+
+// VoteReview is a unary RPC to vote for a review as helpful (or to
+// withdraw the vote), as the authenticated user.
+func (server *LaptopServer) VoteReview(ctx context.Context, req *pb.VoteReviewRequest) (*pb.VoteReviewResponse, error) {
+	reviewId := req.GetReviewId()
+	log.Printf("Received a vote-review request with id %s, helpful = %t", reviewId, req.GetHelpful())
+
+	claims := users.FromContext(ctx)
+	if claims == nil {
+		return nil, logError(nil, codes.Unauthenticated, "Only the authenticated users can vote")
+	}
+
+	_, err := uuid.Parse(reviewId)
+	if err != nil {
+		msg := fmt.Sprintf("The review ID %q is not a valid UUID", reviewId)
+		return nil, logError(err, codes.InvalidArgument, msg)
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	if server.store.review == nil {
+		return nil, logError(nil, codes.Unimplemented, "Reviews are not supported")
+	}
+
+	review, err := server.store.review.Vote(reviewId, claims.Username, req.GetHelpful())
+	if err != nil {
+		code := codes.Internal
+		switch {
+		case errors.Is(err, stores.ErrorNotFound):
+			code = codes.NotFound
+		case errors.Is(err, stores.ErrorOwnReview):
+			code = codes.PermissionDenied
+		}
+
+		return nil, logError(err, code, "Cannot vote for review")
+	}
+
+	return &pb.VoteReviewResponse{Review: review}, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/stores"
+	"google.golang.org/grpc/codes"
+)
+
+// Number of laptops of `TopRatedLaptops`.
+const (
+	defaultTopRated = 10
+	maxTopRated     = 100
+)
+
+// RatingPrior damps the average scores ranking the top rated laptops:
+// a laptop is ranked as if it also had `Weight` scores of `Mean`, so
+// that a single 10/10 doesn't beat hundreds of 9/10. The mean should
+// be on the rating scale, e.g. its middle or the average of all the
+// scores, and the weight about the usual number of scores of a laptop.
+type RatingPrior struct {
+	Mean   float64
+	Weight float64
+}
+
+// Default prior, on the default rating scale.
+var defaultRatingPrior = RatingPrior{Mean: 5.5, Weight: 10}
+
+// WithRatingPrior sets the prior of the damped averages of the scores
+// (see `Check`).
+func WithRatingPrior(prior RatingPrior) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.ratingPrior = prior
+	}
+}
+
+// Check returns an error if the prior cannot damp the scores of `scale`:
+// its weight must be positive, and its mean on the scale.
+func (prior RatingPrior) Check(scale RatingScale) error {
+	if !(prior.Weight > 0) || math.IsInf(prior.Weight, 0) {
+		return fmt.Errorf("Prior weight %v is not positive", prior.Weight)
+	}
+	if !(prior.Mean >= scale.Min && prior.Mean <= scale.Max) {
+		return fmt.Errorf("Prior mean %v is not between %v and %v", prior.Mean, scale.Min, scale.Max)
+	}
+	return nil
+}
+
+// damped returns the damped average of the scores of a rating.
+func (prior RatingPrior) damped(rating stores.Rating) float64 {
+	weight := prior.Weight + float64(rating.Count)
+	if weight <= 0 {
+		return 0
+	}
+	return (prior.Mean*prior.Weight + rating.Sum) / weight
+}
+
+// TopRatedLaptops is a unary RPC to get the best rated laptops matching a
+// filter, ranked by the damped averages of their scores. As the averages
+// depend on the prior, and the filter on any field of the laptops, each
+// request copies all the ratings and scans all the laptops matching the
+// filter (through an index if any): O(n) with n laptops, plus O(n log k)
+// to keep the k best ones.
+func (server *LaptopServer) TopRatedLaptops(ctx context.Context, req *pb.TopRatedLaptopsRequest) (*pb.TopRatedLaptopsResponse, error) {
+	filter := req.GetFilter()
+	minRatings := req.GetMinRatings()
+	log.Printf("Received a top-rated-laptops request with filter %v and min ratings %d", filter, minRatings)
+
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultTopRated
+	}
+	if limit > maxTopRated {
+		limit = maxTopRated
+	}
+
+	// Ranking from a copy of the ratings, so that the ratings
+	// streaming in are not blocked during the search (and
+	// don't change the ranking while searching).
+	ratings := make(map[string]stores.Rating)
+	if server.store.rating != nil {
+		var err error
+		ratings, err = server.store.rating.All()
+		if err != nil {
+			return nil, logError(err, codes.Internal, "Cannot get the ratings")
+		}
+	}
+
+	prior := server.ratingPrior
+	options := &stores.SearchOptions{
+		SortKey: func(laptop *pb.Laptop) float64 {
+			return prior.damped(ratings[laptop.GetId()])
+		},
+		Descending: true,
+		Limit:      limit,
+		Match: func(laptop *pb.Laptop) bool {
+			return ratings[laptop.GetId()].Count >= minRatings
+		},
+	}
+
+	response := &pb.TopRatedLaptopsResponse{}
+	found := func(laptop *pb.Laptop) error {
+		rating := ratings[laptop.GetId()]
+		rated := &pb.TopRatedLaptopsResponse_RatedLaptop{
+			Laptop:      laptop,
+			RatedCount:  rating.Count,
+			DampedScore: prior.damped(rating),
+		}
+		if rating.Count > 0 {
+			rated.AverageScore = rating.Sum / float64(rating.Count)
+		}
+		response.Laptops = append(response.Laptops, rated)
+		return nil
+	}
+
+	err := server.store.laptop.Search(ctx, filter, options, found)
+	if err != nil {
+		if err := contextError(ctx); err != nil {
+			return nil, err
+		}
+		return nil, logError(err, codes.Internal, "Unexpected error")
+	}
+
+	return response, nil
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aleg/go-grpc-laptops/images"
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/stores"
+	"google.golang.org/protobuf/proto"
+)
+
+// How long an interrupted upload can be resumed.
+const uploadSessionTTL = time.Hour
+
+// How often the expired upload sessions are discarded.
+const uploadSessionCleanup = time.Minute
+
+var (
+	errorSessionMismatch = errors.New("Upload session started with another image info")
+	errorSessionBusy     = errors.New("Upload session is already in use")
+	errorSessionOwner    = errors.New("Upload session started by another user")
+)
+
+// uploadSession is an upload of an image, that can
+// go on over multiple `UploadImage` requests.
+type uploadSession struct {
+	id     string // empty if not resumable
+	owner  string // username of the user uploading the image (if any)
+	info   *pb.UploadImageRequest_ImageInfo
+	upload stores.ImageUpload
+	digest hash.Hash // SHA-256 of the image received so far
+	header []byte    // first bytes of the image, to check its type
+
+	// Protected by the mutex of the sessions.
+	offset     uint64 // size of the image received so far
+	busy       bool   // being uploaded by a request
+	lastActive time.Time
+}
+
+// write writes the chunk of the image at the end of the session.
+func (session *uploadSession) write(chunk []byte) error {
+	_, err := session.upload.Write(chunk)
+	if err != nil {
+		return err
+	}
+
+	session.digest.Write(chunk)
+	return nil
+}
+
+// checkFormat checks that the image has the declared type,
+// once enough of its first bytes are received.
+func (session *uploadSession) checkFormat(chunk []byte) error {
+	missing := images.SniffLen - len(session.header)
+	if missing <= 0 {
+		return nil // already checked
+	}
+	if missing > len(chunk) {
+		missing = len(chunk)
+	}
+
+	session.header = append(session.header, chunk[:missing]...)
+	if len(session.header) < images.SniffLen {
+		return nil
+	}
+
+	_, err := images.Check(session.header, session.info.GetImageType())
+	return err
+}
+
+// checkDigest tells whether the image received
+// matches the SHA-256 of the image info (if any).
+func (session *uploadSession) checkDigest() bool {
+	expected := session.info.GetSha256()
+	return len(expected) == 0 || bytes.Equal(expected, session.digest.Sum(nil))
+}
+
+// uploadSessions are the resumable uploads of a server. The sessions
+// not resumed in time are discarded in the background.
+type uploadSessions struct {
+	m        sync.Mutex
+	sessions map[string]*uploadSession
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func startUploadSessions() *uploadSessions {
+	sessions := &uploadSessions{
+		sessions: make(map[string]*uploadSession),
+		done:     make(chan struct{}),
+	}
+
+	sessions.wg.Add(1)
+	go sessions.cleanup()
+
+	return sessions
+}
+
+// stop stops discarding the expired sessions.
+func (sessions *uploadSessions) stop() {
+	close(sessions.done)
+	sessions.wg.Wait()
+}
+
+func (sessions *uploadSessions) cleanup() {
+	defer sessions.wg.Done()
+
+	ticker := time.NewTicker(uploadSessionCleanup)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sessions.done:
+			return
+		case <-ticker.C:
+			sessions.m.Lock()
+			sessions.removeExpired()
+			sessions.m.Unlock()
+		}
+	}
+}
+
+// acquire returns the session of an upload by a user, marked as busy.
+// If there is no such session (or the upload is not resumable), a new
+// one is created using `create`. A session can be resumed only by the
+// user who started it.
+func (sessions *uploadSessions) acquire(info *pb.UploadImageRequest_ImageInfo, owner string, create func() (stores.ImageUpload, error)) (*uploadSession, error) {
+	sessionId := info.GetSessionId()
+	if len(sessionId) == 0 {
+		upload, err := create()
+		if err != nil {
+			return nil, err
+		}
+		return &uploadSession{owner: owner, info: info, upload: upload, digest: sha256.New(), busy: true}, nil
+	}
+
+	sessions.m.Lock()
+	defer sessions.m.Unlock()
+
+	// Not waiting for the cleanup, for the sessions expired in the meantime.
+	sessions.removeExpired()
+
+	session, found := sessions.sessions[sessionId]
+	if found {
+		if session.owner != owner {
+			return nil, errorSessionOwner
+		}
+		if !proto.Equal(session.info, info) {
+			return nil, errorSessionMismatch
+		}
+		if session.busy {
+			return nil, errorSessionBusy
+		}
+
+		session.busy = true
+		return session, nil
+	}
+
+	upload, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	session = &uploadSession{
+		id:     sessionId,
+		owner:  owner,
+		info:   info,
+		upload: upload,
+		digest: sha256.New(),
+		busy:   true,
+	}
+	sessions.sessions[sessionId] = session
+	return session, nil
+}
+
+// advance records that `n` more bytes of the session have been received.
+func (sessions *uploadSessions) advance(session *uploadSession, n int) {
+	sessions.m.Lock()
+	defer sessions.m.Unlock()
+
+	session.offset += uint64(n)
+}
+
+// release releases a session acquired by a request. The session is
+// discarded if `done` (or not resumable), otherwise it can be resumed.
+func (sessions *uploadSessions) release(session *uploadSession, done bool) {
+	sessions.m.Lock()
+	defer sessions.m.Unlock()
+
+	session.busy = false
+	session.lastActive = time.Now()
+
+	if done || len(session.id) == 0 {
+		delete(sessions.sessions, session.id)
+		session.upload.Abort() // nothing to do if committed
+	}
+}
+
+// find returns the offset and the total size of a session
+// of a user (not found if started by another user).
+func (sessions *uploadSessions) find(sessionId string, owner string) (uint64, uint64, bool) {
+	sessions.m.Lock()
+	defer sessions.m.Unlock()
+
+	session, found := sessions.sessions[sessionId]
+	if !found || session.owner != owner {
+		return 0, 0, false
+	}
+
+	return session.offset, session.info.GetTotalSize(), true
+}
+
+// removeExpired discards the sessions not resumed in time.
+// The mutex must be held.
+func (sessions *uploadSessions) removeExpired() {
+	for sessionId, session := range sessions.sessions {
+		if !session.busy && time.Since(session.lastActive) > uploadSessionTTL {
+			log.Printf("Upload session %s expired", sessionId)
+			delete(sessions.sessions, sessionId)
+			session.upload.Abort()
+		}
+	}
+}
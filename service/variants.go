@@ -0,0 +1,149 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aleg/go-grpc-laptops/images"
+	"github.com/aleg/go-grpc-laptops/stores"
+)
+
+// Max number of images waiting for their variants.
+const variantQueueSize = 256
+
+// Default sizes of the image variants (max width and height in pixels).
+var defaultVariantSizes = []int{128, 512}
+
+// variantWorkers generate the resized variants of the uploaded
+// images in the background, with a bounded number of goroutines.
+type variantWorkers struct {
+	store     stores.ImageStore
+	sizes     []int
+	maxPixels int         // of the images decoded
+	jobs      chan string // image IDs
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func startVariantWorkers(store stores.ImageStore, sizes []int, workers int, maxPixels int) *variantWorkers {
+	variants := &variantWorkers{
+		store:     store,
+		sizes:     sizes,
+		maxPixels: maxPixels,
+		jobs:      make(chan string, variantQueueSize),
+		done:      make(chan struct{}),
+	}
+
+	variants.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go variants.work()
+	}
+
+	return variants
+}
+
+// enqueue schedules the generation of the variants of an image. It
+// never blocks: the image is skipped if there are too many images
+// waiting already.
+func (variants *variantWorkers) enqueue(imageId string) {
+	if len(variants.sizes) == 0 {
+		return
+	}
+
+	select {
+	case <-variants.done:
+	case variants.jobs <- imageId:
+	default:
+		log.Printf("Cannot generate the variants of image %s: too many images waiting", imageId)
+	}
+}
+
+// stop stops the workers, once done with the current images
+// (the variants of the images still waiting are not generated).
+func (variants *variantWorkers) stop() {
+	close(variants.done)
+	variants.wg.Wait()
+}
+
+func (variants *variantWorkers) work() {
+	defer variants.wg.Done()
+
+	for {
+		select {
+		case <-variants.done:
+			return
+		case imageId := <-variants.jobs:
+			err := variants.generate(imageId)
+			if err != nil {
+				log.Printf("Cannot generate the variants of image %s: %v", imageId, err)
+			}
+		}
+	}
+}
+
+// generate generates and saves the variants of an image
+// (only the ones not saved already for identical images).
+func (variants *variantWorkers) generate(imageId string) error {
+	info, err := variants.store.Find(imageId)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return nil // deleted in the meantime
+	}
+
+	var sizes []int
+	for _, size := range variants.sizes {
+		if _, found := info.Variants[size]; !found {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	file, err := variants.store.Open(imageId, 0)
+	if errors.Is(err, stores.ErrorNotFound) {
+		return nil // deleted in the meantime
+	}
+	if err != nil {
+		return err
+	}
+
+	// The images saved before lowering the max pixels are skipped too.
+	img, format, err := images.Decode(file, variants.maxPixels)
+	file.Close()
+	if errors.Is(err, images.ErrorUnsupported) || errors.Is(err, images.ErrorTooLarge) {
+		log.Printf("No variants for image %s: %v", imageId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only photos are better as JPEG.
+	if format != images.JPEG {
+		format = images.PNG
+	}
+
+	for _, size := range sizes {
+		var buffer bytes.Buffer
+		err := images.Encode(&buffer, images.Resize(img, size), format)
+		if err != nil {
+			return fmt.Errorf("Cannot encode variant %d: %w", size, err)
+		}
+
+		err = variants.store.SaveVariant(imageId, size, &buffer)
+		if errors.Is(err, stores.ErrorNotFound) {
+			return nil // deleted in the meantime
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot save variant %d: %w", size, err)
+		}
+	}
+
+	return nil
+}
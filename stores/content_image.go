@@ -0,0 +1,405 @@
+package stores
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ContentImageStore is an image store keeping the data of identical
+// images only once. The image files are named after the SHA-256 of
+// their data, in subfolders by the first bytes of the hash (e.g.
+// `ab/cd/abcd...89.jpg`), and are removed with the last image using
+// them. The variants of the images are shared the same way.
+// The images are persisted in a journal of the image folder, like
+// the ones of a `DiskImageStore`.
+type ContentImageStore struct {
+	m           sync.RWMutex
+	imageFolder string
+	images      map[string]*contentImage // imageId => image
+	contents    map[string]*imageContent // SHA-256 (hex) => content
+	journal     *imageJournal
+}
+
+// contentImage is an image of a laptop, linked to its content.
+type contentImage struct {
+	info    *ImageInfo // without the variants, kept by the content
+	content *imageContent
+}
+
+// imageContent is the data shared by identical images.
+type imageContent struct {
+	digest   string // SHA-256 (hex)
+	path     string
+	refs     int // number of images linked to the content
+	variants map[int]ImageVariant
+}
+
+// NewContentImageStore returns the image store of `imageFolder`, with
+// the images saved before (see `Check` for their consistency).
+func NewContentImageStore(imageFolder string) *ContentImageStore {
+	removeUploadFiles(imageFolder)
+
+	st := &ContentImageStore{
+		imageFolder: imageFolder,
+		images:      make(map[string]*contentImage),
+		contents:    make(map[string]*imageContent),
+		journal: newImageJournal(imageFolder, contentJournalName, func(name string) string {
+			return contentFilePath(imageFolder, name)
+		}),
+	}
+
+	images, err := st.journal.load()
+	if err != nil {
+		// Going on without the images, and without
+		// compacting the journal to keep them.
+		log.Printf("Cannot load the images of folder %s: %v", imageFolder, err)
+		return st
+	}
+
+	for _, info := range images {
+		st.link(info)
+	}
+	for imageId, image := range st.images {
+		images[imageId] = st.imageInfo(image)
+	}
+	err = st.journal.compact(images)
+	if err != nil {
+		log.Printf("Cannot compact the image journal of folder %s: %v", imageFolder, err)
+	}
+	log.Printf("Loaded %d images (%d contents) from folder %s", len(st.images), len(st.contents), imageFolder)
+
+	return st
+}
+
+// link links a loaded image to its content, named after its digest.
+// The variants saved for any of the identical images are merged.
+func (st *ContentImageStore) link(info *ImageInfo) {
+	name := filepath.Base(info.Path)
+	key := strings.TrimSuffix(name, filepath.Ext(name))
+
+	content, found := st.contents[key]
+	if !found {
+		content = &imageContent{digest: key, path: info.Path}
+		st.contents[key] = content
+	}
+	for size, variant := range info.Variants {
+		if content.variants == nil {
+			content.variants = make(map[int]ImageVariant)
+		}
+		content.variants[size] = variant
+	}
+
+	content.refs++
+	info.Variants = nil
+	st.images[info.Id] = &contentImage{info: info, content: content}
+}
+
+// Implements the `Save` method of the `ImageStore` interface.
+func (st *ContentImageStore) Save(laptopId string, imageType string, imageData io.Reader) (string, error) {
+	return saveImage(st, laptopId, imageType, imageData)
+}
+
+// Implements the `Create` method of the `ImageStore` interface.
+// The image is written to a temporary file of the image folder, that
+// is moved when the upload is committed (or removed if the same data
+// is already stored).
+func (st *ContentImageStore) Create(laptopId string, imageType string, owner string) (ImageUpload, error) {
+	return newDiskImageUpload(st.imageFolder, laptopId, imageType, owner, st.save)
+}
+
+// save saves a committed image, from its temporary file.
+func (st *ContentImageStore) save(info *ImageInfo, digest []byte, tempPath string) error {
+	key := hex.EncodeToString(digest)
+
+	// Moving the file under the lock, so that it cannot be
+	// removed by the deletion of an identical image.
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	content, found := st.contents[key]
+	if !found {
+		content = &imageContent{digest: key, path: st.contentPath(key, info.Type)}
+
+		dir := filepath.Dir(content.path)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("Cannot create image folder: %w", err)
+		}
+		err = os.Rename(tempPath, content.path)
+		if err != nil {
+			return fmt.Errorf("Cannot rename image file: %w", err)
+		}
+		syncDir(dir)
+	}
+
+	info.Path = content.path
+	image := &contentImage{info: info.clone(), content: content}
+	err := st.journal.append(imageRecord{Op: imageOpPut, Image: st.imageInfo(image)})
+	if err != nil {
+		if !found {
+			os.Remove(content.path)
+		}
+		return err
+	}
+
+	st.contents[key] = content
+	content.refs++
+	st.images[info.Id] = image
+	log.Printf("Image %s linked to content %s (%d images)", info.Id, key, content.refs)
+
+	return nil
+}
+
+// contentPath returns the path of the file of some content.
+func (st *ContentImageStore) contentPath(digest string, suffix string) string {
+	return contentFilePath(st.imageFolder, digest+suffix)
+}
+
+// contentFilePath returns the path of a content file from its name,
+// in the subfolders of the first bytes of the digest.
+func contentFilePath(imageFolder string, name string) string {
+	if len(name) < 4 {
+		return filepath.Join(imageFolder, name) // not a digest
+	}
+	return filepath.Join(imageFolder, name[0:2], name[2:4], name)
+}
+
+// recordContent records the images linked to some content,
+// with its variants. The lock must be held.
+func (st *ContentImageStore) recordContent(content *imageContent) error {
+	for _, image := range st.images {
+		if image.content != content {
+			continue
+		}
+		err := st.journal.append(imageRecord{Op: imageOpPut, Image: st.imageInfo(image)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageInfo returns the info of an image, with its variants.
+// The lock must be held.
+func (st *ContentImageStore) imageInfo(image *contentImage) *ImageInfo {
+	info := image.info.clone()
+	if len(image.content.variants) > 0 {
+		info.Variants = make(map[int]ImageVariant, len(image.content.variants))
+		for size, variant := range image.content.variants {
+			info.Variants[size] = variant
+		}
+	}
+	return info
+}
+
+func (st *ContentImageStore) Find(imageId string) (*ImageInfo, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	image, found := st.images[imageId]
+	if !found {
+		return nil, nil
+	}
+
+	return st.imageInfo(image), nil
+}
+
+func (st *ContentImageStore) Open(imageId string, variant int) (io.ReadCloser, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	image, found := st.images[imageId]
+	if !found {
+		return nil, ErrorNotFound
+	}
+
+	path := image.content.path
+	if variant > 0 {
+		imageVariant, found := image.content.variants[variant]
+		if !found {
+			return nil, ErrorNotFound
+		}
+		path = imageVariant.Path
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open image file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (st *ContentImageStore) List(laptopId string) ([]*ImageInfo, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	var images []*ImageInfo
+	for _, image := range st.images {
+		if image.info.LaptopId == laptopId {
+			images = append(images, st.imageInfo(image))
+		}
+	}
+
+	sortImages(images)
+	return images, nil
+}
+
+// Implements the `Usage` method of the `ImageStore` interface.
+// Identical images are counted once per image (each image
+// could be the only one left).
+func (st *ContentImageStore) Usage(laptopId string, owner string) (ImageUsage, ImageUsage, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	var laptop, user ImageUsage
+	for _, image := range st.images {
+		laptop.add(image.info, image.info.LaptopId == laptopId)
+		user.add(image.info, len(owner) > 0 && image.info.Owner == owner)
+	}
+
+	return laptop, user, nil
+}
+
+func (st *ContentImageStore) SetPrimary(imageId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	primary, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
+
+	err := st.journal.append(imageRecord{Op: imageOpPrimary, ImageId: imageId})
+	if err != nil {
+		return err
+	}
+
+	// Only one primary image per laptop.
+	for _, image := range st.images {
+		if image.info.LaptopId == primary.info.LaptopId {
+			image.info.Primary = image == primary
+		}
+	}
+
+	return nil
+}
+
+func (st *ContentImageStore) Delete(imageId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	image, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
+
+	return st.unlink(imageId, image)
+}
+
+func (st *ContentImageStore) DeleteByLaptop(laptopId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	// Going on with the other images even if one of
+	// them cannot be removed, and reporting the first error.
+	var firstErr error
+	for imageId, image := range st.images {
+		if image.info.LaptopId != laptopId {
+			continue
+		}
+
+		err := st.unlink(imageId, image)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// unlink deletes an image, and the files of its content if no
+// other image is linked to it. The lock must be held.
+func (st *ContentImageStore) unlink(imageId string, image *contentImage) error {
+	content := image.content
+	if content.refs == 1 {
+		err := removeImageFiles(&ImageInfo{Path: content.path, Variants: content.variants})
+		if err != nil {
+			return err
+		}
+	}
+
+	// As for a `DiskImageStore`, a crash before the record
+	// leaves an image without its files, that `Check` can find.
+	err := st.journal.append(imageRecord{Op: imageOpDelete, ImageId: imageId})
+	if err != nil {
+		return err
+	}
+
+	content.refs--
+	if content.refs == 0 {
+		delete(st.contents, content.digest)
+		log.Printf("Removed content %s", content.digest)
+	}
+	delete(st.images, imageId)
+	log.Printf("Deleted image %s of laptop %s", imageId, image.info.LaptopId)
+
+	return nil
+}
+
+// Implements the `SaveVariant` method of the `ImageStore` interface.
+// The variant is saved only once for identical images.
+func (st *ContentImageStore) SaveVariant(imageId string, size int, imageData io.Reader) error {
+	tempPath, imageSize, config, err := writeImageFile(st.imageFolder, imageData)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath) // nothing to do once renamed
+
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	image, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
+
+	content := image.content
+	if _, found := content.variants[size]; found {
+		return nil // saved for an identical image
+	}
+
+	suffix := fmt.Sprintf("_%d%s", size, config.Format.Extension)
+	variant := ImageVariant{
+		Type:   config.Format.Extension,
+		Path:   st.contentPath(content.digest, suffix),
+		Size:   imageSize,
+		Width:  config.Width,
+		Height: config.Height,
+	}
+	err = os.Rename(tempPath, variant.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot rename image file: %w", err)
+	}
+	syncDir(filepath.Dir(variant.Path))
+
+	if content.variants == nil {
+		content.variants = make(map[int]ImageVariant)
+	}
+	content.variants[size] = variant
+	err = st.recordContent(content)
+	if err != nil {
+		delete(content.variants, size)
+		os.Remove(variant.Path)
+		return err
+	}
+	log.Printf("Variant %d of content %s saved to file %s", size, content.digest, variant.Path)
+
+	return nil
+}
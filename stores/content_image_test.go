@@ -0,0 +1,246 @@
+package stores
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentImageStore(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	st := NewContentImageStore(imageFolder)
+
+	laptopId := uuid.New().String()
+	otherLaptopId := uuid.New().String()
+	imageData := newTestImage(t, 3, 2)
+	otherImageData := newTestImage(t, 2, 3)
+
+	// The same image for two laptops.
+	imageId, err := st.Save(laptopId, ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	sameImageId, err := st.Save(otherLaptopId, "", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	otherImageId, err := st.Save(laptopId, ".png", bytes.NewReader(otherImageData))
+	require.NoError(t, err)
+	require.NotEqual(t, imageId, sameImageId)
+
+	info, err := st.Find(imageId)
+	require.NoError(t, err)
+	sameInfo, err := st.Find(sameImageId)
+	require.NoError(t, err)
+	require.Equal(t, info.Path, sameInfo.Path)
+	require.Equal(t, otherLaptopId, sameInfo.LaptopId)
+
+	digest := sha256.Sum256(imageData)
+	key := hex.EncodeToString(digest[:])
+	require.Equal(t, filepath.Join(imageFolder, key[0:2], key[2:4], key+".png"), info.Path)
+	require.Len(t, imageFiles(t, imageFolder), 2)
+
+	// The variants are shared too.
+	err = st.SaveVariant(imageId, 2, bytes.NewReader(newTestImage(t, 2, 1)))
+	require.NoError(t, err)
+	sameInfo, err = st.Find(sameImageId)
+	require.NoError(t, err)
+	require.Contains(t, sameInfo.Variants, 2)
+	err = st.SaveVariant(sameImageId, 2, bytes.NewReader(newTestImage(t, 2, 1)))
+	require.NoError(t, err)
+	require.Len(t, imageFiles(t, imageFolder), 3)
+
+	// Deleted with the last image.
+	err = st.Delete(imageId)
+	require.NoError(t, err)
+	require.FileExists(t, info.Path)
+	file, err := st.Open(sameImageId, 2)
+	require.NoError(t, err)
+	file.Close()
+
+	err = st.DeleteByLaptop(otherLaptopId)
+	require.NoError(t, err)
+	require.NoFileExists(t, info.Path)
+	require.Len(t, imageFiles(t, imageFolder), 1)
+
+	_, err = st.Open(sameImageId, 0)
+	require.ErrorIs(t, err, ErrorNotFound)
+	err = st.SaveVariant(sameImageId, 2, bytes.NewReader(newTestImage(t, 2, 1)))
+	require.ErrorIs(t, err, ErrorNotFound)
+
+	// Stored again once deleted.
+	imageId, err = st.Save(otherLaptopId, ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	info, err = st.Find(imageId)
+	require.NoError(t, err)
+	require.FileExists(t, info.Path)
+
+	err = st.Delete(otherImageId)
+	require.NoError(t, err)
+	err = st.Delete(imageId)
+	require.NoError(t, err)
+	require.Empty(t, imageFiles(t, imageFolder))
+}
+
+func TestContentImageStoreReload(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	st := NewContentImageStore(imageFolder)
+
+	laptopId := uuid.New().String()
+	imageData := newTestImage(t, 3, 2)
+	imageId, err := st.Save(laptopId, ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	sameImageId, err := st.Save(laptopId, ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	otherImageId, err := st.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 2, 3)))
+	require.NoError(t, err)
+	require.NoError(t, st.SaveVariant(imageId, 2, bytes.NewReader(newTestImage(t, 2, 1))))
+	require.NoError(t, st.SetPrimary(sameImageId))
+	require.NoError(t, st.Delete(imageId))
+
+	images, err := st.List(laptopId)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+
+	// Reloaded twice, from the journal and from the compacted journal.
+	for i := 0; i < 2; i++ {
+		st = NewContentImageStore(imageFolder)
+
+		reloaded, err := st.List(laptopId)
+		require.NoError(t, err)
+		require.Len(t, reloaded, len(images))
+		for j, info := range reloaded {
+			require.Equal(t, images[j].Id, info.Id)
+			require.Equal(t, images[j].Path, info.Path)
+			require.Equal(t, images[j].Primary, info.Primary)
+			require.Equal(t, images[j].Variants, info.Variants)
+			require.True(t, images[j].UploadedAt.Equal(info.UploadedAt))
+		}
+		require.Equal(t, sameImageId, reloaded[0].Id)
+		require.True(t, reloaded[0].Primary)
+		require.Contains(t, reloaded[0].Variants, 2)
+
+		check, err := st.Check(false)
+		require.NoError(t, err)
+		require.True(t, check.Consistent())
+	}
+
+	// Still shared once reloaded.
+	sameInfo, err := st.Find(sameImageId)
+	require.NoError(t, err)
+	imageId, err = st.Save(laptopId, ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.NoError(t, st.Delete(sameImageId))
+	require.FileExists(t, sameInfo.Path)
+	require.NoError(t, st.Delete(imageId))
+	require.NoError(t, st.Delete(otherImageId))
+	require.Empty(t, imageFiles(t, imageFolder))
+
+	st = NewContentImageStore(imageFolder)
+	images, err = st.List(laptopId)
+	require.NoError(t, err)
+	require.Empty(t, images)
+}
+
+func TestContentImageStoreCheck(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	st := NewContentImageStore(imageFolder)
+
+	laptopId := uuid.New().String()
+	var infos []*ImageInfo
+	for i := 0; i < 3; i++ {
+		imageId, err := st.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 3, i+1)))
+		require.NoError(t, err)
+		require.NoError(t, st.SaveVariant(imageId, 2, bytes.NewReader(newTestImage(t, 2, 1))))
+
+		info, err := st.Find(imageId)
+		require.NoError(t, err)
+		infos = append(infos, info)
+	}
+	// Identical to the image with a dangling variant.
+	sameImageId, err := st.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 3, 2)))
+	require.NoError(t, err)
+
+	// An orphan file, a dangling image and a dangling variant.
+	orphanPath := filepath.Join(imageFolder, "ab", "cd", "abcd.png")
+	require.NoError(t, os.MkdirAll(filepath.Dir(orphanPath), 0755))
+	require.NoError(t, os.WriteFile(orphanPath, newTestImage(t, 3, 2), 0644))
+	require.NoError(t, os.Remove(infos[0].Path))
+	// The files of a `DiskImageStore` are not orphans.
+	diskStore := NewDiskImageStore(imageFolder)
+	_, err = diskStore.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 3, 2)))
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(infos[1].Variants[2].Path))
+
+	st = NewContentImageStore(imageFolder)
+
+	check, err := st.Check(false)
+	require.NoError(t, err)
+	require.Equal(t, []string{orphanPath}, check.OrphanFiles)
+	require.Equal(t, []string{infos[0].Id}, check.DanglingImages)
+	require.Equal(t, []string{infos[1].Variants[2].Path}, check.DanglingVariants)
+	require.False(t, check.Repaired)
+	require.FileExists(t, orphanPath)
+
+	check, err = st.Check(true)
+	require.NoError(t, err)
+	require.False(t, check.Consistent())
+	require.True(t, check.Repaired)
+	require.NoFileExists(t, orphanPath)
+	require.NoFileExists(t, infos[0].Variants[2].Path)
+
+	check, err = diskStore.Check(false)
+	require.NoError(t, err)
+	require.True(t, check.Consistent())
+
+	// Repaired for good.
+	for _, st := range []*ContentImageStore{st, NewContentImageStore(imageFolder)} {
+		check, err = st.Check(false)
+		require.NoError(t, err)
+		require.True(t, check.Consistent())
+
+		images, err := st.List(laptopId)
+		require.NoError(t, err)
+		require.Len(t, images, 3)
+		for _, info := range images {
+			if info.Id == infos[2].Id {
+				require.Equal(t, infos[2].Variants, info.Variants)
+			} else {
+				require.Contains(t, []string{infos[1].Id, sameImageId}, info.Id)
+				require.Empty(t, info.Variants)
+			}
+		}
+	}
+}
+
+// newTestImage returns a PNG image of `width`x`height` pixels.
+func newTestImage(t *testing.T, width int, height int) []byte {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, image.NewGray(image.Rect(0, 0, width, height)))
+	require.NoError(t, err)
+	return buffer.Bytes()
+}
+
+// imageFiles returns the paths of the files in `imageFolder`,
+// without the journals.
+func imageFiles(t *testing.T, imageFolder string) []string {
+	var paths []string
+	err := filepath.Walk(imageFolder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() != imageJournalName && info.Name() != contentJournalName {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return paths
+}
@@ -0,0 +1,193 @@
+package stores
+
+import (
+	"strings"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+)
+
+// 1 pound = 0.45359237 kilograms.
+const KgPerLb = 0.45359237
+
+func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
+	if max := filter.GetMaxPriceUsd(); max > 0 && laptop.GetPriceUsd() > max {
+		return false
+	}
+
+	if laptop.GetPriceUsd() < filter.GetMinPriceUsd() {
+		return false
+	}
+
+	if laptop.GetCpu().GetNumberCores() < filter.GetMinCpuCores() {
+		return false
+	}
+
+	if laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() {
+		return false
+	}
+
+	if ToBit(laptop.GetRam()) < ToBit(filter.GetMinRam()) {
+		return false
+	}
+
+	if !ContainsFold(filter.GetBrands(), laptop.GetBrand()) {
+		return false
+	}
+
+	if !ContainsFold(filter.GetNames(), laptop.GetName()) {
+		return false
+	}
+
+	if !hasQualifiedGPU(filter, laptop) {
+		return false
+	}
+
+	if storageSize(laptop, pb.Storage_SSD) < ToBit(filter.GetMinSsd()) {
+		return false
+	}
+
+	if storageSize(laptop, pb.Storage_HDD) < ToBit(filter.GetMinHdd()) {
+		return false
+	}
+
+	if !hasQualifiedScreen(filter, laptop.GetScreen()) {
+		return false
+	}
+
+	if !hasQualifiedKeyboard(filter, laptop.GetKeyboard()) {
+		return false
+	}
+
+	if !hasQualifiedWeight(filter, laptop) {
+		return false
+	}
+
+	if laptop.GetReleaseYear() < filter.GetMinReleaseYear() {
+		return false
+	}
+
+	if max := filter.GetMaxReleaseYear(); max > 0 && laptop.GetReleaseYear() > max {
+		return false
+	}
+
+	return true
+}
+
+// ContainsFold tells whether `value` is in `set` (ignoring the case).
+// Any value is in an empty set.
+func ContainsFold(set []string, value string) bool {
+	if len(set) == 0 {
+		return true
+	}
+
+	for _, other := range set {
+		if strings.EqualFold(other, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasQualifiedGPU tells whether one of the GPUs of the laptop
+// satisfies all the GPU constraints of the filter.
+func hasQualifiedGPU(filter *pb.Filter, laptop *pb.Laptop) bool {
+	minMemory := ToBit(filter.GetMinGpuMemory())
+	if minMemory == 0 && len(filter.GetGpuBrands()) == 0 {
+		return true
+	}
+
+	for _, gpu := range laptop.GetGpus() {
+		if ToBit(gpu.GetMemory()) >= minMemory && ContainsFold(filter.GetGpuBrands(), gpu.GetBrand()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// storageSize is the total size (in bits) of the storages
+// of the laptop with the given driver.
+func storageSize(laptop *pb.Laptop, driver pb.Storage_Driver) uint64 {
+	var size uint64
+	for _, storage := range laptop.GetStorages() {
+		if storage.GetDriver() == driver {
+			size += ToBit(storage.GetMemory())
+		}
+	}
+
+	return size
+}
+
+func hasQualifiedScreen(filter *pb.Filter, screen *pb.Screen) bool {
+	if screen.GetSizeInch() < filter.GetMinScreenInch() {
+		return false
+	}
+
+	if max := filter.GetMaxScreenInch(); max > 0 && screen.GetSizeInch() > max {
+		return false
+	}
+
+	minResolution := filter.GetMinScreenResolution()
+	if screen.GetResolution().GetWidth() < minResolution.GetWidth() ||
+		screen.GetResolution().GetHeight() < minResolution.GetHeight() {
+		return false
+	}
+
+	if panels := filter.GetScreenPanels(); len(panels) > 0 {
+		for _, panel := range panels {
+			if panel == screen.GetPanel() {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func hasQualifiedKeyboard(filter *pb.Filter, keyboard *pb.Keyboard) bool {
+	// The filter can be nil (no filter at all).
+	if filter != nil && filter.KeyboardBacklit != nil && filter.GetKeyboardBacklit() != keyboard.GetBacklit() {
+		return false
+	}
+
+	if layouts := filter.GetKeyboardLayouts(); len(layouts) > 0 {
+		for _, layout := range layouts {
+			if layout == keyboard.GetLayout() {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func hasQualifiedWeight(filter *pb.Filter, laptop *pb.Laptop) bool {
+	min, max := filter.GetMinWeightKg(), filter.GetMaxWeightKg()
+	if min == 0 && max == 0 {
+		return true
+	}
+
+	// A laptop without weight cannot satisfy any weight constraint.
+	kg, ok := weightKg(laptop)
+	if !ok {
+		return false
+	}
+
+	return kg >= min && (max == 0 || kg <= max)
+}
+
+// weightKg returns the weight of the laptop in kilograms,
+// whatever unit it was given in.
+func weightKg(laptop *pb.Laptop) (float64, bool) {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return weight.WeightKg, true
+	case *pb.Laptop_WeightLb:
+		return weight.WeightLb * KgPerLb, true
+	default:
+		return 0, false
+	}
+}
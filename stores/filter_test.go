@@ -0,0 +1,156 @@
+package stores
+
+import (
+	"testing"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/sample"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsQualified(t *testing.T) {
+	t.Parallel()
+
+	gb := func(value uint64) *pb.Memory {
+		return &pb.Memory{Value: value, Unit: pb.Memory_GIGABYTE}
+	}
+	backlit := true
+
+	// "Lenovo, SSD >= 512GB, >= 14-inch OLED, under 1.8 kg, 2018 or newer".
+	filter := &pb.Filter{
+		Brands:         []string{"lenovo"},
+		MinSsd:         gb(512),
+		MinScreenInch:  14,
+		ScreenPanels:   []pb.Screen_Panel{pb.Screen_OLED},
+		MaxWeightKg:    1.8,
+		MinReleaseYear: 2018,
+	}
+
+	newLaptop := func() *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.Brand = sample.LENOVO
+		laptop.Storages = []*pb.Storage{
+			{Driver: pb.Storage_SSD, Memory: gb(256)},
+			{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 256 << 10, Unit: pb.Memory_MEGABYTE}},
+			{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 2, Unit: pb.Memory_TERABYTE}},
+		}
+		laptop.Screen.SizeInch = 14
+		laptop.Screen.Panel = pb.Screen_OLED
+		laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 3.9} // 1.77 kg
+		laptop.ReleaseYear = 2018
+		return laptop
+	}
+
+	testCases := []struct {
+		name      string
+		filter    *pb.Filter
+		update    func(laptop *pb.Laptop)
+		qualified bool
+	}{
+		{
+			name:      "qualified",
+			filter:    filter,
+			update:    func(laptop *pb.Laptop) {},
+			qualified: true,
+		},
+		{
+			name:      "empty_filter",
+			filter:    &pb.Filter{},
+			update:    func(laptop *pb.Laptop) {},
+			qualified: true,
+		},
+		{
+			name:      "nil_filter",
+			filter:    nil,
+			update:    func(laptop *pb.Laptop) {},
+			qualified: true,
+		},
+		{
+			name:   "other_brand",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Brand = sample.DELL },
+		},
+		{
+			name:   "small_ssd",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Storages = laptop.Storages[1:] },
+		},
+		{
+			name:   "small_screen",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Screen.SizeInch = 13.9 },
+		},
+		{
+			name:   "ips_panel",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Screen.Panel = pb.Screen_IPS },
+		},
+		{
+			name:   "heavy_in_pounds",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 4} },
+		},
+		{
+			name:   "heavy_in_kilograms",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightKg{WeightKg: 1.81} },
+		},
+		{
+			name:   "no_weight",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.Weight = nil },
+		},
+		{
+			name:   "too_old",
+			filter: filter,
+			update: func(laptop *pb.Laptop) { laptop.ReleaseYear = 2017 },
+		},
+		{
+			name:   "gpu_brand_and_memory_on_different_gpus",
+			filter: &pb.Filter{MinGpuMemory: gb(4), GpuBrands: []string{sample.NVIDIA}},
+			update: func(laptop *pb.Laptop) {
+				laptop.Gpus = []*pb.GPU{
+					{Brand: sample.NVIDIA, Memory: gb(2)},
+					{Brand: sample.AMD, Memory: gb(8)},
+				}
+			},
+		},
+		{
+			name:   "gpu_brand_and_memory",
+			filter: &pb.Filter{MinGpuMemory: gb(4), GpuBrands: []string{sample.NVIDIA}},
+			update: func(laptop *pb.Laptop) {
+				laptop.Gpus = []*pb.GPU{{Brand: sample.NVIDIA, Memory: gb(4)}}
+			},
+			qualified: true,
+		},
+		{
+			name:   "price_range",
+			filter: &pb.Filter{MinPriceUsd: 1000, MaxPriceUsd: 2000},
+			update: func(laptop *pb.Laptop) { laptop.PriceUsd = 999 },
+		},
+		{
+			name:   "low_resolution",
+			filter: &pb.Filter{MinScreenResolution: &pb.Screen_Resolution{Width: 1920, Height: 1080}},
+			update: func(laptop *pb.Laptop) {
+				laptop.Screen.Resolution = &pb.Screen_Resolution{Width: 1920, Height: 1079}
+			},
+		},
+		{
+			name:   "keyboard_not_backlit",
+			filter: &pb.Filter{KeyboardBacklit: &backlit},
+			update: func(laptop *pb.Laptop) { laptop.Keyboard.Backlit = false },
+		},
+		{
+			name:      "keyboard_layout",
+			filter:    &pb.Filter{KeyboardLayouts: []pb.Keyboard_Layout{pb.Keyboard_QWERTY}},
+			update:    func(laptop *pb.Laptop) { laptop.Keyboard.Layout = pb.Keyboard_QWERTY },
+			qualified: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		laptop := newLaptop()
+		tc.update(laptop)
+		require.Equal(t, tc.qualified, isQualified(tc.filter, laptop), tc.name)
+	}
+}
@@ -1,68 +1,389 @@
 package stores
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
 	"sync"
-
-	"github.com/google/uuid"
+	"time"
 )
 
+// DiskImageStore is an image store keeping the images in a folder,
+// with a journal of their info to load them again after a restart.
 type DiskImageStore struct {
 	// There will be concurrent requests to write
 	// to file, so a mutex is needed.
 	m           sync.RWMutex          // multiple readers, one writer
 	imageFolder string                // folder path
 	images      map[string]*ImageInfo // imageId => imageInfo
+	journal     *imageJournal         // written under the write lock
 }
 
 type ImageInfo struct {
-	LaptopId string
-	Type     string
-	Path     string
+	Id         string               `json:"id"`
+	LaptopId   string               `json:"laptop_id"`
+	Owner      string               `json:"owner,omitempty"` // username of the uploader
+	Type       string               `json:"type"`            // extension of the image file
+	Path       string               `json:"path"`
+	Size       int64                `json:"size"`   // in bytes
+	Width      int                  `json:"width"`  // in pixels
+	Height     int                  `json:"height"` // in pixels
+	UploadedAt time.Time            `json:"uploaded_at"`
+	Primary    bool                 `json:"primary,omitempty"`  // at most one primary image per laptop
+	Variants   map[int]ImageVariant `json:"variants,omitempty"` // by size (max width and height)
 }
 
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	images := make(map[string]*ImageInfo)
-	return &DiskImageStore{imageFolder: imageFolder, images: images}
+// ImageVariant is a resized version of an image.
+type ImageVariant struct {
+	Type   string `json:"type"` // extension of the image file
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`   // in bytes
+	Width  int    `json:"width"`  // in pixels
+	Height int    `json:"height"` // in pixels
+}
+
+// clone returns a copy of the info, that can be changed
+// outside of the lock of the store.
+func (info *ImageInfo) clone() *ImageInfo {
+	other := *info
+	if info.Variants != nil {
+		other.Variants = make(map[int]ImageVariant, len(info.Variants))
+		for size, variant := range info.Variants {
+			other.Variants[size] = variant
+		}
+	}
+	return &other
 }
 
-func (st *DiskImageStore) Save(laptopId string, imageType string, imageData bytes.Buffer) (string, error) {
-	// Generating the image ID.
-	imageId, err := uuid.NewRandom()
+// NewDiskImageStore returns the image store of `imageFolder`, with
+// the images saved before (see `Check` for their consistency).
+func NewDiskImageStore(imageFolder string) *DiskImageStore {
+	removeUploadFiles(imageFolder)
+
+	journal := newImageJournal(imageFolder, imageJournalName, func(name string) string {
+		return fmt.Sprintf("%s/%s", imageFolder, name)
+	})
+	images, err := journal.load()
 	if err != nil {
-		return "", fmt.Errorf("Cannot generate image id: %w", err)
+		// Going on with the images loaded, without
+		// compacting the journal to keep the others.
+		log.Printf("Cannot load the images of folder %s: %v", imageFolder, err)
+		images = make(map[string]*ImageInfo)
+	} else {
+		err = journal.compact(images)
+		if err != nil {
+			log.Printf("Cannot compact the image journal of folder %s: %v", imageFolder, err)
+		}
+		log.Printf("Loaded %d images from folder %s", len(images), imageFolder)
 	}
 
+	return &DiskImageStore{imageFolder: imageFolder, images: images, journal: journal}
+}
+
+// Implements the `Save` method of the `ImageStore` interface.
+func (st *DiskImageStore) Save(laptopId string, imageType string, imageData io.Reader) (string, error) {
+	return saveImage(st, laptopId, imageType, imageData)
+}
+
+// Implements the `Create` method of the `ImageStore` interface.
+// The image is written to a temporary file of the image folder, that
+// is renamed when the upload is committed (and removed if aborted).
+// The extension of the file is the one of the actual image format.
+func (st *DiskImageStore) Create(laptopId string, imageType string, owner string) (ImageUpload, error) {
+	return newDiskImageUpload(st.imageFolder, laptopId, imageType, owner, st.save)
+}
+
+// save saves a committed image, from its temporary file.
+func (st *DiskImageStore) save(info *ImageInfo, digest []byte, tempPath string) error {
+	// Acquiring the lock to update the in-memory counterpart of
+	// the image data (and to rename the file, so that `Check`
+	// never finds the file without its image).
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
 	// Generating the image path.
-	imagePath := fmt.Sprintf("%s/%s%s", st.imageFolder, imageId, imageType)
-	log.Printf("Saving image %s to file %s...", imageId.String(), imagePath)
+	info.Path = fmt.Sprintf("%s/%s%s", st.imageFolder, info.Id, info.Type)
+
+	err := os.Rename(tempPath, info.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot rename image file: %w", err)
+	}
+	syncDir(st.imageFolder)
+
+	err = st.journal.append(imageRecord{Op: imageOpPut, Image: info})
+	if err != nil {
+		os.Remove(info.Path)
+		return err
+	}
+
+	st.images[info.Id] = info.clone()
+	return nil
+}
+
+func (st *DiskImageStore) Find(imageId string) (*ImageInfo, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	info, found := st.images[imageId]
+	if !found {
+		return nil, nil
+	}
+
+	// Returning a copy, the info must not be changed outside of the lock.
+	return info.clone(), nil
+}
+
+func (st *DiskImageStore) Open(imageId string, variant int) (io.ReadCloser, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	info, found := st.images[imageId]
+	if !found {
+		return nil, ErrorNotFound
+	}
+
+	path := info.Path
+	if variant > 0 {
+		imageVariant, found := info.Variants[variant]
+		if !found {
+			return nil, ErrorNotFound
+		}
+		path = imageVariant.Path
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open image file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (st *DiskImageStore) List(laptopId string) ([]*ImageInfo, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	var images []*ImageInfo
+	for _, info := range st.images {
+		if info.LaptopId == laptopId {
+			images = append(images, info.clone())
+		}
+	}
+
+	sortImages(images)
+	return images, nil
+}
+
+func (st *DiskImageStore) Usage(laptopId string, owner string) (ImageUsage, ImageUsage, error) {
+	st.m.RLock() // read lock
+	defer st.m.RUnlock()
+
+	var laptop, user ImageUsage
+	for _, info := range st.images {
+		laptop.add(info, info.LaptopId == laptopId)
+		user.add(info, len(owner) > 0 && info.Owner == owner)
+	}
+
+	return laptop, user, nil
+}
+
+func (st *DiskImageStore) SetPrimary(imageId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	primary, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
+
+	err := st.journal.append(imageRecord{Op: imageOpPrimary, ImageId: imageId})
+	if err != nil {
+		return err
+	}
+
+	// Only one primary image per laptop.
+	for _, info := range st.images {
+		if info.LaptopId == primary.LaptopId {
+			info.Primary = info == primary
+		}
+	}
+
+	return nil
+}
+
+func (st *DiskImageStore) Delete(imageId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	info, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
 
-	// Creating the file at the generated path.
-	file, err := os.Create(imagePath)
+	err := st.remove(info)
 	if err != nil {
-		return "", fmt.Errorf("Cannot create image file: %w", err)
+		return err
 	}
 
-	// Writing the image data to the new file.
-	_, err = imageData.WriteTo(file)
+	log.Printf("Deleted image %s of laptop %s", imageId, info.LaptopId)
+	return nil
+}
+
+func (st *DiskImageStore) DeleteByLaptop(laptopId string) error {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	// Going on with the other images even if one of
+	// them cannot be removed, and reporting the first error.
+	var firstErr error
+	for imageId, info := range st.images {
+		if info.LaptopId != laptopId {
+			continue
+		}
+
+		err := st.remove(info)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		log.Printf("Deleted image %s of laptop %s", imageId, laptopId)
+	}
+
+	return firstErr
+}
+
+// remove removes an image and its files. The write lock must be held.
+func (st *DiskImageStore) remove(info *ImageInfo) error {
+	err := removeImageFiles(info)
+	if err != nil {
+		return err
+	}
+
+	// A crash before the record leaves an image without its files,
+	// that `Check` can find. An image must never be lost while its
+	// files are still there.
+	err = st.journal.append(imageRecord{Op: imageOpDelete, ImageId: info.Id})
 	if err != nil {
-		return "", fmt.Errorf("Cannot write image data to file: %w", err)
+		return err
 	}
-	log.Printf("Image %s save to file %s...", imageId.String(), imagePath)
 
-	// Acquiring the lock to update the in-memory
-	// counterpart of the image data.
+	delete(st.images, info.Id)
+	return nil
+}
+
+func (st *DiskImageStore) SaveVariant(imageId string, size int, imageData io.Reader) error {
+	tempPath, imageSize, config, err := writeImageFile(st.imageFolder, imageData)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath) // nothing to do once renamed
+
 	st.m.Lock() // write lock
 	defer st.m.Unlock()
 
-	st.images[imageId.String()] = &ImageInfo{
-		LaptopId: laptopId,
-		Type:     imageType,
-		Path:     imagePath,
+	// Renaming the file under the lock, so that it cannot be
+	// left behind if the image is deleted in the meantime.
+	info, found := st.images[imageId]
+	if !found {
+		return ErrorNotFound
+	}
+
+	_, replaced := info.Variants[size]
+	variant := ImageVariant{
+		Type:   config.Format.Extension,
+		Path:   fmt.Sprintf("%s/%s_%d%s", st.imageFolder, imageId, size, config.Format.Extension),
+		Size:   imageSize,
+		Width:  config.Width,
+		Height: config.Height,
+	}
+	err = os.Rename(tempPath, variant.Path)
+	if err != nil {
+		return fmt.Errorf("Cannot rename image file: %w", err)
+	}
+	syncDir(st.imageFolder)
+
+	other := info.clone()
+	if other.Variants == nil {
+		other.Variants = make(map[int]ImageVariant)
+	}
+	other.Variants[size] = variant
+	err = st.journal.append(imageRecord{Op: imageOpPut, Image: other})
+	if err != nil {
+		if !replaced {
+			os.Remove(variant.Path)
+		}
+		return err
+	}
+
+	st.images[imageId] = other
+	log.Printf("Variant %d of image %s saved to file %s", size, imageId, variant.Path)
+
+	return nil
+}
+
+// add adds an image to the usage, if `counted`.
+func (usage *ImageUsage) add(info *ImageInfo, counted bool) {
+	if counted {
+		usage.Images++
+		usage.Bytes += info.Size
+	}
+}
+
+// saveImage saves an image to a store in a single upload
+// (whatever its number of pixels).
+func saveImage(st ImageStore, laptopId string, imageType string, imageData io.Reader) (string, error) {
+	upload, err := st.Create(laptopId, imageType, "")
+	if err != nil {
+		return "", err
+	}
+	defer upload.Abort() // nothing to do once committed
+
+	_, err = io.Copy(upload, imageData)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := upload.Commit(0)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Id, nil
+}
+
+// sortImages sorts the images of a laptop: the
+// primary image first, and then by upload time.
+func sortImages(images []*ImageInfo) {
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if a.Primary != b.Primary {
+			return a.Primary
+		}
+		if !a.UploadedAt.Equal(b.UploadedAt) {
+			return a.UploadedAt.Before(b.UploadedAt)
+		}
+		return a.Id < b.Id
+	})
+}
+
+// removeImageFiles removes the files of an image and of its variants.
+func removeImageFiles(info *ImageInfo) error {
+	paths := []string{info.Path}
+	for _, variant := range info.Variants {
+		paths = append(paths, variant.Path)
+	}
+
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Cannot remove image file %s: %w", path, err)
+		}
 	}
 
-	return imageId.String(), nil
+	return nil
 }
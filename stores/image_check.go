@@ -0,0 +1,238 @@
+package stores
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ImageCheck is the report of a consistency check of an image store.
+type ImageCheck struct {
+	OrphanFiles      []string // paths of the files without an image
+	DanglingImages   []string // IDs of the images without their file
+	DanglingVariants []string // paths of the missing files of variants
+	Repaired         bool
+}
+
+// Consistent tells whether the images match the files.
+func (check *ImageCheck) Consistent() bool {
+	return len(check.OrphanFiles) == 0 && len(check.DanglingImages) == 0 && len(check.DanglingVariants) == 0
+}
+
+// Check checks that the images match the files of the image folder,
+// e.g. after a crash. When `repair` is set, the orphan files are removed
+// and the dangling images (or variants) are deleted.
+func (st *DiskImageStore) Check(repair bool) (*ImageCheck, error) {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	files, err := folderImageFiles(st.imageFolder, false)
+	if err != nil {
+		return nil, err
+	}
+	check, danglingVariants := checkImageFiles(st.images, files)
+
+	if !repair || check.Consistent() {
+		return check, nil
+	}
+
+	err = removeOrphanFiles(check.OrphanFiles)
+	if err != nil {
+		return check, err
+	}
+
+	for _, imageId := range check.DanglingImages {
+		err := st.remove(st.images[imageId])
+		if err != nil {
+			return check, err
+		}
+		log.Printf("Deleted dangling image %s", imageId)
+	}
+
+	for _, info := range danglingVariants {
+		other := info.clone()
+		for size, variant := range other.Variants {
+			if !fileExists(variant.Path) {
+				delete(other.Variants, size)
+			}
+		}
+
+		err := st.journal.append(imageRecord{Op: imageOpPut, Image: other})
+		if err != nil {
+			return check, err
+		}
+		st.images[other.Id] = other
+		log.Printf("Deleted the dangling variants of image %s", other.Id)
+	}
+
+	check.Repaired = true
+	return check, nil
+}
+
+// Check checks that the images match the files of the subfolders
+// of the image folder, like the `Check` of a `DiskImageStore`.
+// The variants missing for identical images are deleted for all of them.
+func (st *ContentImageStore) Check(repair bool) (*ImageCheck, error) {
+	st.m.Lock() // write lock
+	defer st.m.Unlock()
+
+	files, err := folderImageFiles(st.imageFolder, true)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]*ImageInfo, len(st.images))
+	for imageId, image := range st.images {
+		images[imageId] = st.imageInfo(image)
+	}
+	check, danglingVariants := checkImageFiles(images, files)
+
+	if !repair || check.Consistent() {
+		return check, nil
+	}
+
+	err = removeOrphanFiles(check.OrphanFiles)
+	if err != nil {
+		return check, err
+	}
+
+	for _, imageId := range check.DanglingImages {
+		err := st.unlink(imageId, st.images[imageId])
+		if err != nil {
+			return check, err
+		}
+		log.Printf("Deleted dangling image %s", imageId)
+	}
+
+	for _, info := range danglingVariants {
+		content := st.images[info.Id].content
+		repaired := false
+		for size, variant := range content.variants {
+			if !fileExists(variant.Path) {
+				delete(content.variants, size)
+				repaired = true
+			}
+		}
+		if !repaired {
+			continue // with another identical image
+		}
+
+		err := st.recordContent(content)
+		if err != nil {
+			return check, err
+		}
+		log.Printf("Deleted the dangling variants of content %s", content.digest)
+	}
+
+	check.Repaired = true
+	return check, nil
+}
+
+// checkImageFiles compares the images of a store with the files of its
+// image folder. It returns the report, and the images with dangling
+// variants (the variants of a dangling image are deleted with it).
+func checkImageFiles(images map[string]*ImageInfo, files []string) (*ImageCheck, []*ImageInfo) {
+	check := &ImageCheck{}
+	used := make(map[string]bool) // paths, shared by identical images
+	dangling := make(map[string]bool)
+	var danglingVariants []*ImageInfo
+
+	for imageId, info := range images {
+		used[filepath.Clean(info.Path)] = true
+		for _, variant := range info.Variants {
+			used[filepath.Clean(variant.Path)] = true
+		}
+
+		if !fileExists(info.Path) {
+			check.DanglingImages = append(check.DanglingImages, imageId)
+			continue
+		}
+
+		found := false
+		for _, variant := range info.Variants {
+			if fileExists(variant.Path) {
+				continue
+			}
+			found = true
+			if !dangling[variant.Path] {
+				dangling[variant.Path] = true
+				check.DanglingVariants = append(check.DanglingVariants, variant.Path)
+			}
+		}
+		if found {
+			danglingVariants = append(danglingVariants, info)
+		}
+	}
+
+	for _, path := range files {
+		if !used[filepath.Clean(path)] {
+			check.OrphanFiles = append(check.OrphanFiles, path)
+		}
+	}
+
+	sort.Strings(check.OrphanFiles)
+	sort.Strings(check.DanglingImages)
+	sort.Strings(check.DanglingVariants)
+	return check, danglingVariants
+}
+
+// folderImageFiles returns the paths of the image files of a folder,
+// without the journals and uploads: the ones of its subfolders if
+// `sharded` (for a `ContentImageStore`), or else its own ones (for a
+// `DiskImageStore`), so that both stores can share a folder.
+func folderImageFiles(imageFolder string, sharded bool) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(imageFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != imageFolder && !sharded {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if sharded && filepath.Dir(path) == filepath.Clean(imageFolder) {
+			return nil
+		}
+
+		name := entry.Name()
+		if name == imageJournalName || name == contentJournalName || isUploadFile(name) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot read image folder: %w", err)
+	}
+
+	return paths, nil
+}
+
+// removeOrphanFiles removes the orphan files found by a check.
+func removeOrphanFiles(paths []string) error {
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Cannot remove orphan file %s: %w", path, err)
+		}
+		log.Printf("Removed orphan image file %s", path)
+	}
+	return nil
+}
+
+// fileExists tells whether a file exists (when in doubt, it does:
+// a repair must not delete an image that cannot be read for a while).
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// isUploadFile tells whether a file is the temporary file of an upload.
+func isUploadFile(name string) bool {
+	matched, _ := filepath.Match(uploadFilePattern, name)
+	return matched
+}
@@ -0,0 +1,181 @@
+package stores
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The journals of the image stores, in their image folder.
+const (
+	imageJournalName   = "images.journal"   // of a `DiskImageStore`
+	contentJournalName = "contents.journal" // of a `ContentImageStore`
+)
+
+// The operations of the journal records.
+const (
+	imageOpPut     = "put"     // saves an image (or replaces it)
+	imageOpDelete  = "delete"  // deletes an image
+	imageOpPrimary = "primary" // makes an image the primary image of its laptop
+)
+
+// imageRecord is a change of the images, one JSON object per line.
+type imageRecord struct {
+	Op      string     `json:"op"`
+	Image   *ImageInfo `json:"image,omitempty"`    // put
+	ImageId string     `json:"image_id,omitempty"` // delete and primary
+}
+
+// imageJournal is an append-only file of the changes of the images,
+// replayed to load the images. It's compacted when loaded.
+type imageJournal struct {
+	imageFolder string
+	name        string // of the journal file
+	// filePath returns the path of an image file from its name,
+	// as the image folder may have been moved since the record.
+	filePath func(name string) string
+	file     *os.File // opened with the first record
+}
+
+func newImageJournal(imageFolder string, name string, filePath func(name string) string) *imageJournal {
+	return &imageJournal{imageFolder: imageFolder, name: name, filePath: filePath}
+}
+
+// append writes a record to the journal, durably.
+func (journal *imageJournal) append(record imageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Cannot encode image record: %w", err)
+	}
+
+	if journal.file == nil {
+		path := filepath.Join(journal.imageFolder, journal.name)
+		journal.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Cannot open image journal: %w", err)
+		}
+	}
+
+	_, err = journal.file.Write(append(data, '\n'))
+	if err == nil {
+		err = journal.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write image journal: %w", err)
+	}
+
+	return nil
+}
+
+// load replays the journal, and returns the images. Invalid records
+// (like the last one, if written partially before a crash) are skipped.
+func (journal *imageJournal) load() (map[string]*ImageInfo, error) {
+	images := make(map[string]*ImageInfo)
+
+	file, err := os.Open(filepath.Join(journal.imageFolder, journal.name))
+	if os.IsNotExist(err) {
+		return images, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open image journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record imageRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			log.Printf("Skipping record %d of the image journal: %v", line, err)
+			continue
+		}
+
+		switch record.Op {
+		case imageOpPut:
+			if record.Image == nil || len(record.Image.Id) == 0 {
+				log.Printf("Skipping record %d of the image journal: no image", line)
+				continue
+			}
+			images[record.Image.Id] = journal.locate(record.Image)
+		case imageOpDelete:
+			delete(images, record.ImageId)
+		case imageOpPrimary:
+			primary, found := images[record.ImageId]
+			if !found {
+				continue
+			}
+			for _, info := range images {
+				if info.LaptopId == primary.LaptopId {
+					info.Primary = info == primary
+				}
+			}
+		default:
+			log.Printf("Skipping record %d of the image journal: unknown operation %q", line, record.Op)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Cannot read image journal: %w", err)
+	}
+
+	return images, nil
+}
+
+// locate makes the paths of an image relative to the current
+// image folder (that may have been moved since the record).
+func (journal *imageJournal) locate(info *ImageInfo) *ImageInfo {
+	info.Path = journal.filePath(filepath.Base(info.Path))
+	for size, variant := range info.Variants {
+		variant.Path = journal.filePath(filepath.Base(variant.Path))
+		info.Variants[size] = variant
+	}
+	return info
+}
+
+// compact replaces the journal with a record per image.
+func (journal *imageJournal) compact(images map[string]*ImageInfo) error {
+	file, err := os.CreateTemp(journal.imageFolder, uploadFilePattern)
+	if err != nil {
+		return fmt.Errorf("Cannot create image journal: %w", err)
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath) // nothing to do once renamed
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, info := range images {
+		err = encoder.Encode(imageRecord{Op: imageOpPut, Image: info})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write image journal: %w", err)
+	}
+
+	err = os.Rename(tempPath, filepath.Join(journal.imageFolder, journal.name))
+	if err != nil {
+		return fmt.Errorf("Cannot rename image journal: %w", err)
+	}
+	syncDir(journal.imageFolder)
+
+	// Appending to the new file from now on.
+	if journal.file != nil {
+		journal.file.Close()
+		journal.file = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package stores
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreReload(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	st := NewDiskImageStore(imageFolder)
+
+	laptopId := uuid.New().String()
+	var imageIds []string
+	for i := 0; i < 3; i++ {
+		imageId, err := st.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 3, 2)))
+		require.NoError(t, err)
+		imageIds = append(imageIds, imageId)
+	}
+	otherImageId, err := st.Save(uuid.New().String(), ".png", bytes.NewReader(newTestImage(t, 2, 3)))
+	require.NoError(t, err)
+
+	require.NoError(t, st.SetPrimary(imageIds[1]))
+	require.NoError(t, st.SetPrimary(imageIds[2]))
+	require.NoError(t, st.SaveVariant(imageIds[2], 2, bytes.NewReader(newTestImage(t, 2, 1))))
+	require.NoError(t, st.Delete(imageIds[0]))
+
+	expected, err := st.List(laptopId)
+	require.NoError(t, err)
+	require.Len(t, expected, 2)
+
+	// Loaded again, twice to load the compacted journal too.
+	for i := 0; i < 2; i++ {
+		st = NewDiskImageStore(imageFolder)
+
+		images, err := st.List(laptopId)
+		require.NoError(t, err)
+		require.Len(t, images, len(expected))
+		for j, info := range images {
+			require.Equal(t, expected[j].Id, info.Id)
+			require.Equal(t, expected[j].Path, info.Path)
+			require.Equal(t, expected[j].Primary, info.Primary)
+			require.Equal(t, expected[j].Variants, info.Variants)
+			require.True(t, expected[j].UploadedAt.Equal(info.UploadedAt))
+		}
+
+		info, err := st.Find(otherImageId)
+		require.NoError(t, err)
+		require.NotNil(t, info)
+
+		check, err := st.Check(false)
+		require.NoError(t, err)
+		require.True(t, check.Consistent())
+	}
+
+	// A record written partially before a crash.
+	journal, err := os.OpenFile(filepath.Join(imageFolder, imageJournalName), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = journal.WriteString(`{"op":"delete","ima`)
+	require.NoError(t, err)
+	require.NoError(t, journal.Close())
+
+	st = NewDiskImageStore(imageFolder)
+	images, err := st.List(laptopId)
+	require.NoError(t, err)
+	require.Len(t, images, len(expected))
+
+	// Going on after it.
+	require.NoError(t, st.Delete(otherImageId))
+	st = NewDiskImageStore(imageFolder)
+	info, err := st.Find(otherImageId)
+	require.NoError(t, err)
+	require.Nil(t, info)
+}
+
+func TestDiskImageStoreCheck(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	st := NewDiskImageStore(imageFolder)
+
+	laptopId := uuid.New().String()
+	var infos []*ImageInfo
+	for i := 0; i < 3; i++ {
+		imageId, err := st.Save(laptopId, ".png", bytes.NewReader(newTestImage(t, 3, 2)))
+		require.NoError(t, err)
+		require.NoError(t, st.SaveVariant(imageId, 2, bytes.NewReader(newTestImage(t, 2, 1))))
+
+		info, err := st.Find(imageId)
+		require.NoError(t, err)
+		infos = append(infos, info)
+	}
+
+	// An orphan file, a dangling image and a dangling variant.
+	orphanPath := filepath.Join(imageFolder, uuid.New().String()+".png")
+	require.NoError(t, os.WriteFile(orphanPath, newTestImage(t, 3, 2), 0644))
+	require.NoError(t, os.Remove(infos[0].Path))
+	require.NoError(t, os.Remove(infos[1].Variants[2].Path))
+
+	st = NewDiskImageStore(imageFolder)
+
+	// In-flight uploads are not orphans.
+	upload, err := st.Create(laptopId, ".png", "")
+	require.NoError(t, err)
+	defer upload.Abort()
+
+	check, err := st.Check(false)
+	require.NoError(t, err)
+	require.Equal(t, []string{orphanPath}, check.OrphanFiles)
+	require.Equal(t, []string{infos[0].Id}, check.DanglingImages)
+	require.Equal(t, []string{infos[1].Variants[2].Path}, check.DanglingVariants)
+	require.False(t, check.Repaired)
+	require.FileExists(t, orphanPath)
+
+	check, err = st.Check(true)
+	require.NoError(t, err)
+	require.False(t, check.Consistent())
+	require.True(t, check.Repaired)
+	require.NoFileExists(t, orphanPath)
+	require.NoFileExists(t, infos[0].Variants[2].Path)
+
+	// Repaired for good.
+	for _, st := range []*DiskImageStore{st, NewDiskImageStore(imageFolder)} {
+		check, err = st.Check(false)
+		require.NoError(t, err)
+		require.True(t, check.Consistent())
+
+		images, err := st.List(laptopId)
+		require.NoError(t, err)
+		require.Len(t, images, 2)
+		require.Equal(t, infos[1].Id, images[0].Id)
+		require.Empty(t, images[0].Variants)
+		require.Equal(t, infos[2].Variants, images[1].Variants)
+	}
+}
@@ -0,0 +1,260 @@
+package stores
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aleg/go-grpc-laptops/images"
+	"github.com/google/uuid"
+)
+
+// The temporary files of the uploads, in the image folder.
+const uploadFilePattern = ".upload-*"
+
+var errorUploadDone = errors.New("Upload already committed or aborted")
+
+// diskImageUpload is an image being uploaded to a temporary file
+// of an image folder, that is moved by `save` once committed.
+type diskImageUpload struct {
+	info   ImageInfo
+	format *images.Format // declared format (nil if any)
+	file   *os.File       // temporary file, nil when committed or aborted
+	digest hash.Hash      // SHA-256 of the image written so far
+	// save moves the image from the temporary file (removed
+	// afterwards if still there) and adds the info of the image.
+	save func(info *ImageInfo, digest []byte, tempPath string) error
+}
+
+func newDiskImageUpload(imageFolder string, laptopId string, imageType string, owner string, save func(*ImageInfo, []byte, string) error) (*diskImageUpload, error) {
+	var format *images.Format
+	if len(imageType) > 0 {
+		var err error
+		format, err = images.ParseType(imageType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Generating the image ID.
+	imageId, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot generate image id: %w", err)
+	}
+
+	file, err := os.CreateTemp(imageFolder, uploadFilePattern)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create image file: %w", err)
+	}
+	log.Printf("Uploading image %s to file %s...", imageId.String(), file.Name())
+
+	upload := &diskImageUpload{
+		info: ImageInfo{
+			Id:       imageId.String(),
+			LaptopId: laptopId,
+			Owner:    owner,
+		},
+		format: format,
+		file:   file,
+		digest: sha256.New(),
+		save:   save,
+	}
+	return upload, nil
+}
+
+func (upload *diskImageUpload) Write(chunk []byte) (int, error) {
+	if upload.file == nil {
+		return 0, errorUploadDone
+	}
+
+	n, err := upload.file.Write(chunk)
+	upload.info.Size += int64(n)
+	upload.digest.Write(chunk[:n])
+	if err != nil {
+		return n, fmt.Errorf("Cannot write image data to file: %w", err)
+	}
+
+	return n, nil
+}
+
+func (upload *diskImageUpload) Commit(maxPixels int) (*ImageInfo, error) {
+	if upload.file == nil {
+		return nil, errorUploadDone
+	}
+
+	// Checking the image before saving it.
+	config, err := images.DecodeConfig(io.NewSectionReader(upload.file, 0, upload.info.Size))
+	if err == nil && upload.format != nil && config.Format != upload.format {
+		err = fmt.Errorf("%w: %s image declared as %s", images.ErrorMismatch, config.Format.Name, upload.format.Name)
+	}
+	if err == nil {
+		err = images.CheckPixels(config, maxPixels)
+	}
+	if err != nil {
+		upload.Abort()
+		return nil, err
+	}
+	upload.info.Type = config.Format.Extension
+	upload.info.Width = config.Width
+	upload.info.Height = config.Height
+
+	// The image must be on disk before being
+	// renamed, otherwise a crash could leave a
+	// truncated image with its final name.
+	err = upload.file.Sync()
+	if err != nil {
+		upload.Abort()
+		return nil, fmt.Errorf("Cannot sync image file: %w", err)
+	}
+
+	tempPath := upload.file.Name()
+	err = upload.file.Close()
+	upload.file = nil
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("Cannot close image file: %w", err)
+	}
+
+	upload.info.UploadedAt = time.Now()
+	info := upload.info
+	err = upload.save(&info, upload.digest.Sum(nil), tempPath)
+	os.Remove(tempPath) // nothing to do once moved
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Image %s saved to file %s", info.Id, info.Path)
+
+	return &info, nil
+}
+
+// Implements the `Rewrite` method of the `ImageUpload` interface.
+// The copy is written to another temporary file, replacing the
+// current one.
+func (upload *diskImageUpload) Rewrite(rewrite func(w io.Writer, r io.Reader) error) error {
+	if upload.file == nil {
+		return errorUploadDone
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(upload.file.Name()), uploadFilePattern)
+	if err != nil {
+		return fmt.Errorf("Cannot create image file: %w", err)
+	}
+
+	digest := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(file, digest))
+	err = rewrite(writer, io.NewSectionReader(upload.file, 0, upload.info.Size))
+	if err == nil {
+		err = writer.Flush()
+	}
+	var size int64
+	if err == nil {
+		size, err = file.Seek(0, io.SeekCurrent)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("Cannot rewrite image file: %w", err)
+	}
+
+	upload.file.Close()
+	os.Remove(upload.file.Name())
+	upload.file = file
+	upload.info.Size = size
+	upload.digest = digest
+
+	return nil
+}
+
+func (upload *diskImageUpload) Size() int64 {
+	return upload.info.Size
+}
+
+func (upload *diskImageUpload) Abort() error {
+	if upload.file == nil {
+		return nil
+	}
+
+	tempPath := upload.file.Name()
+	upload.file.Close()
+	upload.file = nil
+
+	err := os.Remove(tempPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove image file: %w", err)
+	}
+
+	log.Printf("Upload of image %s aborted", upload.info.Id)
+	return nil
+}
+
+// syncDir makes a rename in `dir` durable
+// (not all the platforms support it).
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	file.Sync()
+}
+
+// removeUploadFiles removes the temporary files
+// of the uploads interrupted by a crash.
+func removeUploadFiles(imageFolder string) {
+	paths, err := filepath.Glob(filepath.Join(imageFolder, uploadFilePattern))
+	if err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil {
+			log.Printf("Cannot remove upload file %s: %v", path, err)
+		}
+	}
+}
+
+// writeImageFile writes an image to a temporary file of `imageFolder`
+// and decodes its header. It returns the path of the file (to be renamed
+// or removed by the caller), and the size of the image.
+func writeImageFile(imageFolder string, imageData io.Reader) (string, int64, *images.Config, error) {
+	file, err := os.CreateTemp(imageFolder, uploadFilePattern)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("Cannot create image file: %w", err)
+	}
+	tempPath := file.Name()
+
+	imageSize, err := io.Copy(file, imageData)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", 0, nil, fmt.Errorf("Cannot write image file: %w", err)
+	}
+
+	file, err = os.Open(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return "", 0, nil, fmt.Errorf("Cannot open image file: %w", err)
+	}
+	config, err := images.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return "", 0, nil, err
+	}
+
+	return tempPath, imageSize, config, nil
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/aleg/go-grpc-laptops/pb"
 	"github.com/jinzhu/copier"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type InMemoryLaptopStore struct {
@@ -17,11 +20,25 @@ type InMemoryLaptopStore struct {
 	m sync.RWMutex // multiple readers, one writer
 	// key: laptop ID; value: laptop object.
 	data map[string]*pb.Laptop
+	// The same laptops of `data`, sorted by each order of `List`.
+	sorted map[pb.ListLaptopsRequest_OrderBy]*sortedLaptops
+	// The same laptops of `data`, indexed for `Search`.
+	indexes *laptopIndexes
+	// The last changes of `data`, and who is watching them.
+	changes *laptopChanges
 }
 
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
+	sorted := make(map[pb.ListLaptopsRequest_OrderBy]*sortedLaptops)
+	for orderBy, less := range listOrders {
+		sorted[orderBy] = newSortedLaptops(less)
+	}
+
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data:    make(map[string]*pb.Laptop),
+		sorted:  sorted,
+		indexes: newLaptopIndexes(),
+		changes: newLaptopChanges(),
 	}
 }
 
@@ -39,9 +56,72 @@ func (st *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	if err != nil {
 		return err
 	}
+	other.UpdatedAt = timestamppb.Now()
 
 	// Saving in the memory st.
 	st.data[other.GetId()] = other
+	for _, view := range st.sorted {
+		view.insert(other)
+	}
+	st.indexes.insert(other)
+	st.changes.add(nil, other)
+
+	return nil
+}
+
+// Implements the `Update` method of the `LaptopStore` interface.
+func (st *InMemoryLaptopStore) Update(laptop *pb.Laptop) (*pb.Laptop, error) {
+	st.m.Lock() // locking for writing. Also reads are blocked.
+	defer st.m.Unlock()
+
+	old, found := st.data[laptop.GetId()]
+	if !found {
+		return nil, ErrorNotFound
+	}
+
+	// Optimistic concurrency: the caller must have
+	// seen the last version of the laptop.
+	if !proto.Equal(old.GetUpdatedAt(), laptop.GetUpdatedAt()) {
+		return nil, ErrorOutdated
+	}
+
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return nil, err
+	}
+	other.UpdatedAt = nextUpdatedAt(old.GetUpdatedAt())
+
+	// The old laptop is not modified but replaced,
+	// as it may be still referenced by the sorted views
+	// and the indexes.
+	st.data[other.GetId()] = other
+	for _, view := range st.sorted {
+		view.remove(old)
+		view.insert(other)
+	}
+	st.indexes.remove(old)
+	st.indexes.insert(other)
+	st.changes.add(old, other)
+
+	return deepCopy(other)
+}
+
+// Implements the `Delete` method of the `LaptopStore` interface.
+func (st *InMemoryLaptopStore) Delete(id string) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	laptop, found := st.data[id]
+	if !found {
+		return ErrorNotFound
+	}
+
+	delete(st.data, id)
+	for _, view := range st.sorted {
+		view.remove(laptop)
+	}
+	st.indexes.remove(laptop)
+	st.changes.add(laptop, nil)
 
 	return nil
 }
@@ -59,61 +139,208 @@ func (st *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return deepCopy(laptop)
 }
 
-func (st *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(*pb.Laptop) error) error {
+// Implements the `List` method of the `LaptopStore` interface.
+func (st *InMemoryLaptopStore) List(orderBy pb.ListLaptopsRequest_OrderBy, cursor string, limit int) ([]*pb.Laptop, string, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	view, found := st.sorted[orderBy]
+	if !found {
+		return nil, "", fmt.Errorf("Unknown order %v", orderBy)
+	}
+
+	var pivot *pb.Laptop
+	if len(cursor) > 0 {
+		var err error
+		pivot, err = decodeCursor(orderBy, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	page, more := view.page(pivot, limit)
+
+	laptops := make([]*pb.Laptop, 0, len(page))
+	for _, laptop := range page {
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return nil, "", err
+		}
+		laptops = append(laptops, other)
+	}
+
+	if !more {
+		return laptops, "", nil
+	}
+
+	next, err := encodeCursor(orderBy, page[len(page)-1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return laptops, next, nil
+}
+
+// Implements the `Search` method of the `LaptopStore` interface.
+// The results are a snapshot of the store taken when the search
+// starts: the lock is released before calling `found`, which may
+// be slow (e.g. sending to a client), so that writers never wait
+// for it. This works because the stored laptops are never modified
+// in place, so the snapshot is just a list of pointers.
+func (st *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, options *SearchOptions, found func(*pb.Laptop) error) error {
+	results, err := st.search(ctx, filter, options)
+	if err != nil {
+		return err
+	}
+
+	for _, laptop := range results {
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return err
+		}
+
+		err = found(other)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// search returns the results of a search, holding the read lock
+// only while looking for them.
+func (st *InMemoryLaptopStore) search(ctx context.Context, filter *pb.Filter, options *SearchOptions) ([]*pb.Laptop, error) {
 	st.m.RLock()
 	defer st.m.RUnlock()
 
-	// Going through each laptop in the store
-	// and searching with `filter`. When something
-	// matches, the callback function `found` is
-	// called.
-	for _, laptop := range st.data {
-		// TODO: heavy processing
-		// time.Sleep(time.Second)
-		log.Print("check laptop id: ", laptop.GetId())
-
-		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
-			log.Print("Search stopped: context is cancelled")
-			return errors.New("Context is cancelled")
+	// Going through the laptops that may match
+	// `filter` (according to the best index) and
+	// checking them. What matches is ranked
+	// according to `options`.
+	plan := st.indexes.plan(filter, st.sorted[pb.ListLaptopsRequest_ID].laptops)
+	log.Printf("Searching %d of %d laptops (index: %s)", plan.size, len(st.data), plan.index)
+
+	top := newTopLaptops(options)
+	for _, laptops := range plan.candidates {
+		for i, laptop := range laptops {
+			// Not checking the context at each laptop, it's too costly.
+			if i%1024 == 0 && (ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded) {
+				log.Print("Search stopped: context is cancelled")
+				return nil, errors.New("Context is cancelled")
+			}
+
+			if isQualified(filter, laptop) && top.match(laptop) && !top.add(laptop) {
+				return top.results(), nil
+			}
 		}
+	}
 
-		if isQualified(filter, laptop) {
-			other, err := deepCopy(laptop)
+	return top.results(), nil
+}
+
+// Implements the `Watch` method of the `LaptopStore` interface.
+// Like `Search`, the lock is never held while calling `changed`.
+func (st *InMemoryLaptopStore) Watch(ctx context.Context, filter *pb.Filter, revision uint64, changed func(*LaptopEvent) error) error {
+	syncing := revision == 0
+	watcher, current, backlog, revision, err := st.watch(revision)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		st.unwatch(watcher) // the last one, when catching up
+	}()
+
+	send := func(event *LaptopEvent) error {
+		if event.Laptop != nil {
+			laptop, err := deepCopy(event.Laptop)
 			if err != nil {
 				return err
 			}
+			event.Laptop = laptop
+		}
+		return changed(event)
+	}
 
-			err = found(other)
+	// Starting with the current matches.
+	if syncing {
+		for _, laptop := range current {
+			if !isQualified(filter, laptop) {
+				continue
+			}
+
+			err = send(&LaptopEvent{Type: pb.WatchLaptopsResponse_CREATED, Laptop: laptop})
 			if err != nil {
 				return err
 			}
 		}
+
+		err = send(&LaptopEvent{Type: pb.WatchLaptopsResponse_SYNCED, Revision: revision})
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	for {
+		for _, change := range backlog {
+			revision = change.revision
+			if event := changeEvent(filter, change); event != nil {
+				err = send(event)
+				if err != nil {
+					return err
+				}
+			}
+		}
 
-func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
-	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
-		return false
-	}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok := <-watcher.changes:
+			if ok {
+				backlog = []*laptopChange{change}
+				continue
+			}
 
-	if laptop.GetCpu().GetNumberCores() < filter.GetMinCpuCores() {
-		return false
+			// Dropped for falling behind: catching
+			// up from the changes kept by the store.
+			log.Printf("Watcher fell behind at revision %d", revision)
+			watcher, _, backlog, _, err = st.watch(revision)
+			if err != nil {
+				return err
+			}
+		}
 	}
+}
+
+// watch registers a new watcher of the changes after `revision`, and
+// returns the changes it missed. If `revision` is 0 it returns the
+// current laptops instead, and the current revision.
+func (st *InMemoryLaptopStore) watch(revision uint64) (*laptopWatcher, []*pb.Laptop, []*laptopChange, uint64, error) {
+	st.m.Lock()
+	defer st.m.Unlock()
 
-	if laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() {
-		return false
+	if revision == 0 {
+		current := append([]*pb.Laptop(nil), st.sorted[pb.ListLaptopsRequest_ID].laptops...)
+		return st.changes.watch(), current, nil, st.changes.revision, nil
 	}
 
-	if toBit(laptop.GetRam()) < toBit(filter.GetMinRam()) {
-		return false
+	backlog, err := st.changes.since(revision)
+	if err != nil {
+		return nil, nil, nil, 0, err
 	}
 
-	return true
+	return st.changes.watch(), nil, backlog, revision, nil
+}
+
+func (st *InMemoryLaptopStore) unwatch(watcher *laptopWatcher) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	st.changes.unwatch(watcher)
 }
 
-func toBit(memory *pb.Memory) uint64 {
+// ToBit converts a memory to bits (0 for an unknown unit).
+func ToBit(memory *pb.Memory) uint64 {
 	value := memory.GetValue()
 
 	switch memory.GetUnit() {
@@ -134,6 +361,18 @@ func toBit(memory *pb.Memory) uint64 {
 	}
 }
 
+// nextUpdatedAt returns the current time, making sure it comes
+// after `prev`: two updates must never share the same `updated_at`,
+// otherwise the second one would not be detected as a change.
+func nextUpdatedAt(prev *timestamppb.Timestamp) *timestamppb.Timestamp {
+	now := time.Now()
+	if prev != nil && !now.After(prev.AsTime()) {
+		now = prev.AsTime().Add(time.Nanosecond)
+	}
+
+	return timestamppb.New(now)
+}
+
 func deepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
 	other := &pb.Laptop{}
 
@@ -0,0 +1,190 @@
+package stores
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+)
+
+// rangeIndex indexes the laptops of a store by a numeric key
+// (e.g. the price), to find the laptops with the key in a range.
+type rangeIndex struct {
+	key  func(laptop *pb.Laptop) float64
+	view *sortedLaptops
+}
+
+func newRangeIndex(key func(laptop *pb.Laptop) float64) *rangeIndex {
+	less := func(a, b *pb.Laptop) bool {
+		ka, kb := key(a), key(b)
+		if ka != kb {
+			return ka < kb
+		}
+		return lessById(a, b)
+	}
+
+	return &rangeIndex{key: key, view: newSortedLaptops(less)}
+}
+
+// between returns the laptops with the key in [min, max].
+func (index *rangeIndex) between(min, max float64) []*pb.Laptop {
+	laptops := index.view.laptops
+	start := sort.Search(len(laptops), func(i int) bool {
+		return index.key(laptops[i]) >= min
+	})
+	end := sort.Search(len(laptops), func(i int) bool {
+		return index.key(laptops[i]) > max
+	})
+
+	if start >= end {
+		return nil
+	}
+	return laptops[start:end]
+}
+
+// hashIndex indexes the laptops of a store by a string key
+// (e.g. the brand), ignoring the case.
+type hashIndex struct {
+	key     func(laptop *pb.Laptop) string
+	buckets map[string]*sortedLaptops // sorted by ID
+}
+
+func newHashIndex(key func(laptop *pb.Laptop) string) *hashIndex {
+	return &hashIndex{key: key, buckets: make(map[string]*sortedLaptops)}
+}
+
+// foldKey returns the same key for strings that are equal
+// ignoring the case (as `strings.EqualFold` does).
+func foldKey(value string) string {
+	return strings.ToLower(strings.ToUpper(value))
+}
+
+func (index *hashIndex) insert(laptop *pb.Laptop) {
+	key := foldKey(index.key(laptop))
+	bucket, found := index.buckets[key]
+	if !found {
+		bucket = newSortedLaptops(lessById)
+		index.buckets[key] = bucket
+	}
+	bucket.insert(laptop)
+}
+
+func (index *hashIndex) remove(laptop *pb.Laptop) {
+	key := foldKey(index.key(laptop))
+	bucket, found := index.buckets[key]
+	if !found {
+		return
+	}
+
+	bucket.remove(laptop)
+	if len(bucket.laptops) == 0 {
+		delete(index.buckets, key)
+	}
+}
+
+// in returns the laptops with any of the keys in `values`
+// (a list of laptops for each key found).
+func (index *hashIndex) in(values []string) [][]*pb.Laptop {
+	seen := make(map[string]bool, len(values))
+	var laptops [][]*pb.Laptop
+	for _, value := range values {
+		key := foldKey(value)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if bucket, found := index.buckets[key]; found {
+			laptops = append(laptops, bucket.laptops)
+		}
+	}
+
+	return laptops
+}
+
+// laptopIndexes are the secondary indexes used by `Search`
+// to avoid scanning all the laptops of a store. They hold
+// the same pointers of the store, like the sorted views.
+type laptopIndexes struct {
+	price    *rangeIndex
+	ram      *rangeIndex
+	cpuCores *rangeIndex
+	brand    *hashIndex
+}
+
+func newLaptopIndexes() *laptopIndexes {
+	return &laptopIndexes{
+		price:    newRangeIndex(laptopSortKeys[pb.SearchLaptopRequest_PRICE]),
+		ram:      newRangeIndex(laptopSortKeys[pb.SearchLaptopRequest_RAM]),
+		cpuCores: newRangeIndex(laptopSortKeys[pb.SearchLaptopRequest_CPU_CORES]),
+		brand: newHashIndex(func(laptop *pb.Laptop) string {
+			return laptop.GetBrand()
+		}),
+	}
+}
+
+func (indexes *laptopIndexes) insert(laptop *pb.Laptop) {
+	indexes.price.view.insert(laptop)
+	indexes.ram.view.insert(laptop)
+	indexes.cpuCores.view.insert(laptop)
+	indexes.brand.insert(laptop)
+}
+
+func (indexes *laptopIndexes) remove(laptop *pb.Laptop) {
+	indexes.price.view.remove(laptop)
+	indexes.ram.view.remove(laptop)
+	indexes.cpuCores.view.remove(laptop)
+	indexes.brand.remove(laptop)
+}
+
+// A plan of a search: the name of the index used, and
+// the laptops that may match the filter.
+type searchPlan struct {
+	index      string
+	candidates [][]*pb.Laptop
+	size       int // total number of candidates
+}
+
+func newSearchPlan(index string, candidates ...[]*pb.Laptop) *searchPlan {
+	plan := &searchPlan{index: index, candidates: candidates}
+	for _, laptops := range candidates {
+		plan.size += len(laptops)
+	}
+	return plan
+}
+
+// plan picks the most selective index for `filter`, i.e. the one
+// with the fewest candidates. All the laptops (`all`) are scanned if
+// no index helps. The candidates must still be checked against the
+// whole filter.
+func (indexes *laptopIndexes) plan(filter *pb.Filter, all []*pb.Laptop) *searchPlan {
+	best := newSearchPlan("none", all)
+	consider := func(plan *searchPlan) {
+		if plan.size < best.size {
+			best = plan
+		}
+	}
+
+	minPrice, maxPrice := filter.GetMinPriceUsd(), filter.GetMaxPriceUsd()
+	if maxPrice <= 0 {
+		maxPrice = math.Inf(1)
+	}
+	if minPrice > 0 || !math.IsInf(maxPrice, 1) {
+		consider(newSearchPlan("price", indexes.price.between(minPrice, maxPrice)))
+	}
+
+	if minRam := ToBit(filter.GetMinRam()); minRam > 0 {
+		consider(newSearchPlan("ram", indexes.ram.between(float64(minRam), math.Inf(1))))
+	}
+
+	if minCores := filter.GetMinCpuCores(); minCores > 0 {
+		consider(newSearchPlan("cpu_cores", indexes.cpuCores.between(float64(minCores), math.Inf(1))))
+	}
+
+	if brands := filter.GetBrands(); len(brands) > 0 {
+		consider(newSearchPlan("brand", indexes.brand.in(brands)...))
+	}
+
+	return best
+}
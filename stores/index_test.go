@@ -0,0 +1,179 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/sample"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchPlan(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < 300; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = float64(1000 + i*10)
+		laptop.Ram = &pb.Memory{Value: uint64(1 + i%64), Unit: pb.Memory_GIGABYTE}
+		laptop.Cpu.NumberCores = uint32(1 + i%16)
+		if i%100 == 0 {
+			laptop.Brand = "Framework"
+		}
+		err := store.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	// Keeping the indexes up to date.
+	laptops, _, err := store.List(pb.ListLaptopsRequest_ID, "", 300)
+	require.NoError(t, err)
+	for _, laptop := range laptops[:50] {
+		laptop.PriceUsd += 5000
+		laptop.Brand = "framework"
+		_, err = store.Update(laptop)
+		require.NoError(t, err)
+	}
+	for _, laptop := range laptops[50:100] {
+		err = store.Delete(laptop.GetId())
+		require.NoError(t, err)
+	}
+
+	gb := func(value uint64) *pb.Memory {
+		return &pb.Memory{Value: value, Unit: pb.Memory_GIGABYTE}
+	}
+
+	testCases := []struct {
+		name   string
+		filter *pb.Filter
+		index  string
+	}{
+		{
+			name:   "no_index",
+			filter: &pb.Filter{MinCpuGhz: 2.5},
+			index:  "none",
+		},
+		{
+			name:   "price",
+			filter: &pb.Filter{MinPriceUsd: 2000, MaxPriceUsd: 2100, MinCpuCores: 2},
+			index:  "price",
+		},
+		{
+			name:   "ram",
+			filter: &pb.Filter{MaxPriceUsd: 3500, MinRam: gb(60)},
+			index:  "ram",
+		},
+		{
+			name:   "cpu_cores",
+			filter: &pb.Filter{MinRam: gb(8), MinCpuCores: 16},
+			index:  "cpu_cores",
+		},
+		{
+			name:   "brand",
+			filter: &pb.Filter{Brands: []string{"FRAMEWORK", "framework"}, MinCpuCores: 2},
+			index:  "brand",
+		},
+		{
+			name:   "unknown_brand",
+			filter: &pb.Filter{Brands: []string{"Acer"}, MinCpuCores: 2},
+			index:  "brand",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store.m.RLock()
+			plan := store.indexes.plan(tc.filter, store.sorted[pb.ListLaptopsRequest_ID].laptops)
+			var expected []string
+			for _, laptop := range store.data {
+				if isQualified(tc.filter, laptop) {
+					expected = append(expected, laptop.GetId())
+				}
+			}
+			store.m.RUnlock()
+			require.Equal(t, tc.index, plan.index)
+
+			var actual []string
+			err := store.Search(context.Background(), tc.filter, nil, func(laptop *pb.Laptop) error {
+				actual = append(actual, laptop.GetId())
+				return nil
+			})
+			require.NoError(t, err)
+
+			sort.Strings(expected)
+			sort.Strings(actual)
+			require.Equal(t, expected, actual)
+		})
+	}
+}
+
+var (
+	benchmarkStore     *InMemoryLaptopStore
+	benchmarkStoreOnce sync.Once
+)
+
+// newBenchmarkStore returns a store of 50k laptops, shared by the benchmarks.
+func newBenchmarkStore() *InMemoryLaptopStore {
+	benchmarkStoreOnce.Do(func() {
+		benchmarkStore = NewInMemoryLaptopStore()
+		for i := 0; i < 50000; i++ {
+			err := benchmarkStore.Save(sample.NewLaptop())
+			if err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	return benchmarkStore
+}
+
+func BenchmarkSearch(b *testing.B) {
+	filters := map[string]*pb.Filter{
+		"price": {MinPriceUsd: 2000, MaxPriceUsd: 2050},
+		"ram":   {MinRam: &pb.Memory{Value: 64, Unit: pb.Memory_GIGABYTE}},
+		"brand": {Brands: []string{"Apple"}, MaxPriceUsd: 2500},
+	}
+
+	store := newBenchmarkStore()
+	for name, filter := range filters {
+		filter := filter
+
+		// All the laptops checked one by one.
+		b.Run(fmt.Sprintf("%s/scan", name), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store.m.RLock()
+				count := 0
+				for _, laptop := range store.sorted[pb.ListLaptopsRequest_ID].laptops {
+					if isQualified(filter, laptop) {
+						count++
+					}
+				}
+				store.m.RUnlock()
+			}
+		})
+
+		// Only the candidates of the best index checked.
+		b.Run(fmt.Sprintf("%s/index", name), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				store.m.RLock()
+				count := 0
+				plan := store.indexes.plan(filter, store.sorted[pb.ListLaptopsRequest_ID].laptops)
+				for _, laptops := range plan.candidates {
+					for _, laptop := range laptops {
+						if isQualified(filter, laptop) {
+							count++
+						}
+					}
+				}
+				store.m.RUnlock()
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package stores
 
 import (
+	"math"
+	"sort"
 	"sync"
 )
 
@@ -8,34 +10,155 @@ type InMemoryRatingStore struct {
 	// There will be concurrent requests to write
 	// a laptop score to memory, so a mutex is needed.
 	m sync.RWMutex // multiple readers, one writer
-	// key: laptop ID; value: Rating.
-	rating map[string]*Rating
+	// key: laptop ID; value: ratings of the laptop.
+	rating map[string]*laptopRatings
+}
+
+// laptopRatings are the scores of a laptop, one per user.
+type laptopRatings struct {
+	scores map[string]float64 // username => score
+	// Rating of the current scores, updated with each score in O(1).
+	// The rounding errors of the sum are negligible next to the
+	// scores (and exact for the scores on a binary step, like 0.5).
+	rating Rating
 }
 
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
-		rating: make(map[string]*Rating),
+		rating: make(map[string]*laptopRatings),
 	}
 }
 
-func (st *InMemoryRatingStore) Add(laptopId string, score float64) (*Rating, error) {
+func (st *InMemoryRatingStore) Rate(laptopId string, username string, score float64) (*Rating, error) {
 	st.m.Lock() // locking for writing. Also reads are blocked.
 	defer st.m.Unlock()
 
-	rating, alreadyExists := st.rating[laptopId]
+	ratings, alreadyExists := st.rating[laptopId]
+	if !alreadyExists {
+		// First rating!
+		ratings = &laptopRatings{scores: make(map[string]float64)}
+		st.rating[laptopId] = ratings
+	}
 
-	if alreadyExists {
-		// Update rating.
-		rating.Count++
-		rating.Sum += score
+	// Replacing the previous score of the user, if any.
+	if previous, found := ratings.scores[username]; found {
+		ratings.rating.Sum -= previous
 	} else {
-		// First rating!
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
+		ratings.rating.Count++
+	}
+	ratings.scores[username] = score
+	ratings.rating.Sum += score
+
+	rating := ratings.rating
+	return &rating, nil
+}
+
+func (st *InMemoryRatingStore) Retract(laptopId string, username string) (*Rating, error) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	ratings, found := st.rating[laptopId]
+	if !found {
+		return nil, ErrorNotFound
+	}
+	score, found := ratings.scores[username]
+	if !found {
+		return nil, ErrorNotFound
+	}
+
+	delete(ratings.scores, username)
+	if len(ratings.scores) == 0 {
+		delete(st.rating, laptopId)
+		return &Rating{}, nil
+	}
+	ratings.rating.Count--
+	ratings.rating.Sum -= score
+
+	rating := ratings.rating
+	return &rating, nil
+}
+
+func (st *InMemoryRatingStore) Find(laptopId string) (*Rating, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	ratings, found := st.rating[laptopId]
+	if !found {
+		return nil, nil
+	}
+
+	// Returning a copy, as the stored rating keeps changing.
+	rating := ratings.rating
+	return &rating, nil
+}
+
+func (st *InMemoryRatingStore) All() (map[string]Rating, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	all := make(map[string]Rating, len(st.rating))
+	for laptopId, ratings := range st.rating {
+		all[laptopId] = ratings.rating
+	}
+
+	return all, nil
+}
+
+func (st *InMemoryRatingStore) Stats(laptopIds ...string) (map[string]*RatingStats, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	stats := make(map[string]*RatingStats, len(laptopIds))
+	for _, laptopId := range laptopIds {
+		if ratings, found := st.rating[laptopId]; found {
+			stats[laptopId] = ratings.stats()
 		}
-		st.rating[laptopId] = rating
 	}
 
-	return rating, nil
+	return stats, nil
+}
+
+// stats computes the aggregates of the scores.
+func (ratings *laptopRatings) stats() *RatingStats {
+	scores := make([]float64, 0, len(ratings.scores))
+	for _, score := range ratings.scores {
+		scores = append(scores, score)
+	}
+	sort.Float64s(scores)
+
+	n := len(scores)
+	stats := &RatingStats{
+		Count: uint32(n),
+		Mean:  ratings.rating.Sum / float64(n),
+	}
+	if n%2 == 1 {
+		stats.Median = scores[n/2]
+	} else {
+		stats.Median = (scores[n/2-1] + scores[n/2]) / 2
+	}
+
+	var squares float64 // sum of the squared deviations
+	for i, score := range scores {
+		squares += (score - stats.Mean) * (score - stats.Mean)
+
+		last := len(stats.Histogram) - 1
+		if i > 0 && score == scores[i-1] {
+			stats.Histogram[last].Count++
+		} else {
+			stats.Histogram = append(stats.Histogram, ScoreBucket{Score: score, Count: 1})
+		}
+	}
+	stats.StdDev = math.Sqrt(squares / float64(n))
+
+	return stats
+}
+
+func (st *InMemoryRatingStore) Delete(laptopId string) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	// Nothing to do for a laptop that has never been rated.
+	delete(st.rating, laptopId)
+
+	return nil
 }
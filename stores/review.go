@@ -0,0 +1,252 @@
+package stores
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type InMemoryReviewStore struct {
+	m sync.RWMutex // multiple readers, one writer
+	// key: review ID; value: review with its voters.
+	reviews map[string]*storedReview
+	// key: laptop ID; value: IDs of the reviews of the laptop by author.
+	byLaptop map[string]map[string]string
+	// Creation time of the last review, so that the
+	// reviews are always created one after the other.
+	lastCreatedAt *timestamppb.Timestamp
+}
+
+type storedReview struct {
+	review *pb.Review
+	voters map[string]bool // usernames of the users who found it helpful
+}
+
+func NewInMemoryReviewStore() *InMemoryReviewStore {
+	return &InMemoryReviewStore{
+		reviews:  make(map[string]*storedReview),
+		byLaptop: make(map[string]map[string]string),
+	}
+}
+
+func (st *InMemoryReviewStore) Save(review *pb.Review) (*pb.Review, error) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	laptopId, author := review.GetLaptopId(), review.GetAuthor()
+	authors, found := st.byLaptop[laptopId]
+	if !found {
+		authors = make(map[string]string)
+		st.byLaptop[laptopId] = authors
+	}
+
+	now := nextUpdatedAt(st.lastCreatedAt)
+	other := proto.Clone(review).(*pb.Review)
+	other.UpdatedAt = now
+
+	if reviewId, found := authors[author]; found {
+		// Replacing the review, but not what others made of it.
+		stored := st.reviews[reviewId]
+		other.Id = reviewId
+		other.CreatedAt = stored.review.GetCreatedAt()
+		other.HelpfulVotes = stored.review.GetHelpfulVotes()
+		stored.review = other
+	} else {
+		other.Id = uuid.New().String()
+		other.CreatedAt = now
+		other.HelpfulVotes = 0
+		st.lastCreatedAt = now
+		st.reviews[other.Id] = &storedReview{review: other, voters: make(map[string]bool)}
+		authors[author] = other.Id
+	}
+
+	return proto.Clone(other).(*pb.Review), nil
+}
+
+func (st *InMemoryReviewStore) Find(laptopId string, author string) (*pb.Review, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	reviewId, found := st.byLaptop[laptopId][author]
+	if !found {
+		return nil, nil
+	}
+
+	return proto.Clone(st.reviews[reviewId].review).(*pb.Review), nil
+}
+
+func (st *InMemoryReviewStore) Vote(reviewId string, voter string, helpful bool) (*pb.Review, error) {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	stored, found := st.reviews[reviewId]
+	if !found {
+		return nil, ErrorNotFound
+	}
+	if stored.review.GetAuthor() == voter {
+		return nil, ErrorOwnReview
+	}
+
+	// Voting again changes nothing.
+	if helpful {
+		stored.voters[voter] = true
+	} else {
+		delete(stored.voters, voter)
+	}
+	stored.review.HelpfulVotes = uint32(len(stored.voters))
+
+	return proto.Clone(stored.review).(*pb.Review), nil
+}
+
+func (st *InMemoryReviewStore) List(laptopId string, orderBy pb.ListReviewsRequest_OrderBy, cursor string, limit int) ([]*pb.Review, string, error) {
+	st.m.RLock()
+	defer st.m.RUnlock()
+
+	less, found := reviewOrders[orderBy]
+	if !found {
+		return nil, "", fmt.Errorf("Unknown order %v", orderBy)
+	}
+
+	var pivot *pb.Review
+	if len(cursor) > 0 {
+		var err error
+		pivot, err = decodeReviewCursor(orderBy, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Sorting the reviews of the laptop on each page, as
+	// their votes keep changing (a laptop has few reviews).
+	var reviews []*pb.Review
+	for _, reviewId := range st.byLaptop[laptopId] {
+		review := st.reviews[reviewId].review
+		if pivot == nil || less(pivot, review) {
+			reviews = append(reviews, review)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		return less(reviews[i], reviews[j])
+	})
+
+	more := limit > 0 && len(reviews) > limit
+	if more {
+		reviews = reviews[:limit]
+	}
+
+	page := make([]*pb.Review, 0, len(reviews))
+	for _, review := range reviews {
+		page = append(page, proto.Clone(review).(*pb.Review))
+	}
+
+	if !more {
+		return page, "", nil
+	}
+
+	next, err := encodeReviewCursor(orderBy, page[len(page)-1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, next, nil
+}
+
+func (st *InMemoryReviewStore) Delete(laptopId string, author string) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	authors := st.byLaptop[laptopId]
+	reviewId, found := authors[author]
+	if !found {
+		return nil
+	}
+
+	delete(st.reviews, reviewId)
+	delete(authors, author)
+	if len(authors) == 0 {
+		delete(st.byLaptop, laptopId)
+	}
+
+	return nil
+}
+
+func (st *InMemoryReviewStore) DeleteByLaptop(laptopId string) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	for _, reviewId := range st.byLaptop[laptopId] {
+		delete(st.reviews, reviewId)
+	}
+	delete(st.byLaptop, laptopId)
+
+	return nil
+}
+
+// reviewOrders are the orders supported by `List`.
+var reviewOrders = map[pb.ListReviewsRequest_OrderBy]func(a, b *pb.Review) bool{
+	pb.ListReviewsRequest_NEWEST:       newerReview,
+	pb.ListReviewsRequest_MOST_HELPFUL: moreHelpfulReview,
+}
+
+func newerReview(a, b *pb.Review) bool {
+	ta, tb := a.GetCreatedAt(), b.GetCreatedAt()
+	if ta.GetSeconds() != tb.GetSeconds() {
+		return ta.GetSeconds() > tb.GetSeconds()
+	}
+	if ta.GetNanos() != tb.GetNanos() {
+		return ta.GetNanos() > tb.GetNanos()
+	}
+	return a.GetId() < b.GetId()
+}
+
+func moreHelpfulReview(a, b *pb.Review) bool {
+	if a.GetHelpfulVotes() != b.GetHelpfulVotes() {
+		return a.GetHelpfulVotes() > b.GetHelpfulVotes()
+	}
+	return newerReview(a, b)
+}
+
+// A review cursor points to the last review of a page, like the
+// cursors of the laptops (see `encodeCursor`). With the votes changing
+// between pages, a review may be listed twice or skipped.
+func encodeReviewCursor(orderBy pb.ListReviewsRequest_OrderBy, review *pb.Review) (string, error) {
+	pivot := &pb.Review{
+		Id:           review.GetId(),
+		HelpfulVotes: review.GetHelpfulVotes(),
+		CreatedAt:    review.GetCreatedAt(),
+	}
+
+	data, err := proto.Marshal(pivot)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal cursor: %w", err)
+	}
+
+	data = append([]byte{byte(orderBy)}, data...)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeReviewCursor(orderBy pb.ListReviewsRequest_OrderBy, cursor string) (*pb.Review, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) == 0 {
+		return nil, ErrorInvalidCursor
+	}
+
+	// A cursor is valid only for the order it was created for.
+	if pb.ListReviewsRequest_OrderBy(data[0]) != orderBy {
+		return nil, ErrorInvalidCursor
+	}
+
+	pivot := &pb.Review{}
+	err = proto.Unmarshal(data[1:], pivot)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+
+	return pivot, nil
+}
@@ -0,0 +1,100 @@
+package stores
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryReviewStore(t *testing.T) {
+	t.Parallel()
+
+	st := NewInMemoryReviewStore()
+	laptopId := uuid.New().String()
+
+	var reviews []*pb.Review
+	for i := 0; i < 5; i++ {
+		review, err := st.Save(&pb.Review{
+			LaptopId: laptopId,
+			Author:   fmt.Sprintf("user%d", i),
+			Score:    float64(i + 1),
+			Title:    fmt.Sprintf("Review %d", i),
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, review.GetId())
+		reviews = append(reviews, review)
+	}
+	_, err := st.Save(&pb.Review{LaptopId: uuid.New().String(), Author: "user0", Title: "Other laptop"})
+	require.NoError(t, err)
+
+	// Votes, once per user and not for their own reviews.
+	for _, vote := range []struct {
+		review int
+		voter  string
+	}{{1, "user0"}, {1, "user2"}, {1, "user2"}, {3, "user0"}} {
+		_, err := st.Vote(reviews[vote.review].GetId(), vote.voter, true)
+		require.NoError(t, err)
+	}
+	_, err = st.Vote(reviews[0].GetId(), "user0", true)
+	require.ErrorIs(t, err, ErrorOwnReview)
+	_, err = st.Vote(uuid.New().String(), "user0", true)
+	require.ErrorIs(t, err, ErrorNotFound)
+
+	review, err := st.Vote(reviews[3].GetId(), "user1", true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), review.GetHelpfulVotes())
+	review, err = st.Vote(reviews[3].GetId(), "user1", false)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), review.GetHelpfulVotes())
+
+	// Replaced, keeping its ID, creation time and votes.
+	review, err = st.Save(&pb.Review{LaptopId: laptopId, Author: "user1", Score: 10, Title: "Changed my mind"})
+	require.NoError(t, err)
+	require.Equal(t, reviews[1].GetId(), review.GetId())
+	require.Equal(t, uint32(2), review.GetHelpfulVotes())
+	require.True(t, reviews[1].GetCreatedAt().AsTime().Equal(review.GetCreatedAt().AsTime()))
+	found, err := st.Find(laptopId, "user1")
+	require.NoError(t, err)
+	require.Equal(t, "Changed my mind", found.GetTitle())
+
+	// Listed in pages.
+	list := func(orderBy pb.ListReviewsRequest_OrderBy) []string {
+		var authors []string
+		cursor := ""
+		for {
+			page, next, err := st.List(laptopId, orderBy, cursor, 2)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), 2)
+			for _, review := range page {
+				authors = append(authors, review.GetAuthor())
+			}
+			if len(next) == 0 {
+				return authors
+			}
+			cursor = next
+		}
+	}
+	require.Equal(t, []string{"user4", "user3", "user2", "user1", "user0"}, list(pb.ListReviewsRequest_NEWEST))
+	require.Equal(t, []string{"user1", "user3", "user4", "user2", "user0"}, list(pb.ListReviewsRequest_MOST_HELPFUL))
+
+	_, next, err := st.List(laptopId, pb.ListReviewsRequest_NEWEST, "", 2)
+	require.NoError(t, err)
+	_, _, err = st.List(laptopId, pb.ListReviewsRequest_MOST_HELPFUL, next, 2)
+	require.ErrorIs(t, err, ErrorInvalidCursor)
+
+	// Deleted.
+	require.NoError(t, st.Delete(laptopId, "user1"))
+	found, err = st.Find(laptopId, "user1")
+	require.NoError(t, err)
+	require.Nil(t, found)
+	_, err = st.Vote(reviews[1].GetId(), "user0", true)
+	require.ErrorIs(t, err, ErrorNotFound)
+
+	require.NoError(t, st.DeleteByLaptop(laptopId))
+	page, _, err := st.List(laptopId, pb.ListReviewsRequest_NEWEST, "", 0)
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
@@ -0,0 +1,147 @@
+package stores
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// sortedLaptops is a view of the laptops of a store, kept sorted
+// by some key (with ties broken by ID, so that the order is total).
+// It holds the same pointers of the store, so the stored laptops
+// must never be modified in place, but replaced by a new copy.
+type sortedLaptops struct {
+	less    func(a, b *pb.Laptop) bool
+	laptops []*pb.Laptop
+}
+
+func newSortedLaptops(less func(a, b *pb.Laptop) bool) *sortedLaptops {
+	return &sortedLaptops{less: less}
+}
+
+// search returns the index of the first laptop that comes after `pivot`.
+func (view *sortedLaptops) search(pivot *pb.Laptop) int {
+	return sort.Search(len(view.laptops), func(i int) bool {
+		return view.less(pivot, view.laptops[i])
+	})
+}
+
+func (view *sortedLaptops) insert(laptop *pb.Laptop) {
+	i := view.search(laptop)
+	view.laptops = append(view.laptops, nil)
+	copy(view.laptops[i+1:], view.laptops[i:])
+	view.laptops[i] = laptop
+}
+
+func (view *sortedLaptops) remove(laptop *pb.Laptop) {
+	// First laptop that doesn't come before `laptop`,
+	// i.e. `laptop` itself.
+	i := sort.Search(len(view.laptops), func(i int) bool {
+		return !view.less(view.laptops[i], laptop)
+	})
+	if i == len(view.laptops) || view.laptops[i] != laptop {
+		return
+	}
+
+	copy(view.laptops[i:], view.laptops[i+1:])
+	view.laptops[len(view.laptops)-1] = nil
+	view.laptops = view.laptops[:len(view.laptops)-1]
+}
+
+// page returns up to `limit` laptops that come after `pivot`
+// (from the first one if `pivot` is nil), and whether there are
+// more laptops after them.
+func (view *sortedLaptops) page(pivot *pb.Laptop, limit int) ([]*pb.Laptop, bool) {
+	start := 0
+	if pivot != nil {
+		start = view.search(pivot)
+	}
+
+	end := start + limit
+	if end >= len(view.laptops) {
+		return view.laptops[start:], false
+	}
+
+	return view.laptops[start:end], true
+}
+
+func lessById(a, b *pb.Laptop) bool {
+	return a.GetId() < b.GetId()
+}
+
+func lessByPrice(a, b *pb.Laptop) bool {
+	if a.GetPriceUsd() != b.GetPriceUsd() {
+		return a.GetPriceUsd() < b.GetPriceUsd()
+	}
+	return lessById(a, b)
+}
+
+func lessByReleaseYear(a, b *pb.Laptop) bool {
+	if a.GetReleaseYear() != b.GetReleaseYear() {
+		return a.GetReleaseYear() < b.GetReleaseYear()
+	}
+	return lessById(a, b)
+}
+
+func lessByUpdatedAt(a, b *pb.Laptop) bool {
+	ta, tb := a.GetUpdatedAt(), b.GetUpdatedAt()
+	if ta.GetSeconds() != tb.GetSeconds() {
+		return ta.GetSeconds() < tb.GetSeconds()
+	}
+	if ta.GetNanos() != tb.GetNanos() {
+		return ta.GetNanos() < tb.GetNanos()
+	}
+	return lessById(a, b)
+}
+
+// listOrders are the orders supported by `List`.
+var listOrders = map[pb.ListLaptopsRequest_OrderBy]func(a, b *pb.Laptop) bool{
+	pb.ListLaptopsRequest_ID:           lessById,
+	pb.ListLaptopsRequest_PRICE:        lessByPrice,
+	pb.ListLaptopsRequest_RELEASE_YEAR: lessByReleaseYear,
+	pb.ListLaptopsRequest_UPDATED_AT:   lessByUpdatedAt,
+}
+
+// A cursor points to the last laptop of a page: it's made of
+// the order it refers to, followed by the fields of the laptop
+// used by the order. Keeping the values (and not just the ID)
+// lets a listing go on even if that laptop is deleted or updated.
+func encodeCursor(orderBy pb.ListLaptopsRequest_OrderBy, laptop *pb.Laptop) (string, error) {
+	pivot := &pb.Laptop{
+		Id:          laptop.GetId(),
+		PriceUsd:    laptop.GetPriceUsd(),
+		ReleaseYear: laptop.GetReleaseYear(),
+		UpdatedAt:   laptop.GetUpdatedAt(),
+	}
+
+	data, err := proto.Marshal(pivot)
+	if err != nil {
+		return "", fmt.Errorf("Cannot marshal cursor: %w", err)
+	}
+
+	data = append([]byte{byte(orderBy)}, data...)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(orderBy pb.ListLaptopsRequest_OrderBy, cursor string) (*pb.Laptop, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) == 0 {
+		return nil, ErrorInvalidCursor
+	}
+
+	// A cursor is valid only for the order it was created for.
+	if pb.ListLaptopsRequest_OrderBy(data[0]) != orderBy {
+		return nil, ErrorInvalidCursor
+	}
+
+	pivot := &pb.Laptop{}
+	err = proto.Unmarshal(data[1:], pivot)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+
+	return pivot, nil
+}
@@ -1,41 +1,193 @@
 package stores
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"io"
 
 	"github.com/aleg/go-grpc-laptops/pb"
 	"github.com/aleg/go-grpc-laptops/users"
 )
 
-var ErrorAlreadyExists = errors.New("Record already exists")
+var (
+	ErrorAlreadyExists = errors.New("Record already exists")
+	ErrorNotFound      = errors.New("Record not found")
+	ErrorInvalidCursor = errors.New("Invalid cursor")
+	// The record has been changed since it was read.
+	ErrorOutdated = errors.New("Record is outdated")
+	// The changes after a revision are not kept anymore.
+	ErrorRevisionCompacted = errors.New("Revision is compacted")
+	ErrorInvalidRevision   = errors.New("Invalid revision")
+	// A user cannot vote for their own review.
+	ErrorOwnReview = errors.New("Review is by the voter")
+)
 
 // LaptopStore is an interface to store laptop
 type LaptopStore interface {
 	// Save saves the laptop to the store
 	Save(laptop *pb.Laptop) error
+	// Update replaces the stored laptop with the same ID, as long as the
+	// stored laptop has still the same `updated_at` of `laptop`
+	// (returns `ErrorOutdated` otherwise), and returns the updated laptop.
+	Update(laptop *pb.Laptop) (*pb.Laptop, error)
+	// Delete deletes the laptop with the given ID from the store
+	// (returns `ErrorNotFound` if there is no such laptop).
+	Delete(id string) error
 	// Find finds a laptop by ID
 	Find(id string) (*pb.Laptop, error)
+	// List returns up to `limit` laptops sorted by `orderBy` (laptops
+	// with the same value are sorted by ID), starting after `cursor`
+	// (from the first laptop if empty). It also returns the cursor
+	// of the next laptops, empty if there are no more laptops.
+	List(orderBy pb.ListLaptopsRequest_OrderBy, cursor string, limit int) ([]*pb.Laptop, string, error)
 	// Search searches a laptop using the provided filter,
 	// and return the results one by one (through a stream)
 	// using the callback function `found`.
-	Search(ctx context.Context, filter *pb.Filter, found func(*pb.Laptop) error) error
+	// The results are sorted and limited by `options` (if not nil).
+	Search(ctx context.Context, filter *pb.Filter, options *SearchOptions, found func(*pb.Laptop) error) error
+	// Watch calls `changed` for each change of the laptops matching
+	// `filter` after `revision`, until `ctx` is done or `changed` returns
+	// an error. If `revision` is 0, the current matches are sent first
+	// (as created), followed by a synced event. It returns
+	// `ErrorRevisionCompacted` if the changes after `revision` are
+	// not available anymore.
+	Watch(ctx context.Context, filter *pb.Filter, revision uint64, changed func(*LaptopEvent) error) error
+}
+
+// LaptopEvent is a change of the laptops matching the filter of a watch.
+// A laptop updated to match the filter is created, and a laptop updated
+// not to match it anymore is deleted.
+type LaptopEvent struct {
+	Type     pb.WatchLaptopsResponse_EventType
+	Laptop   *pb.Laptop // last version of the laptop (nil if synced)
+	Revision uint64     // revision of the store after the change (0 for current matches)
+}
+
+// SearchOptions sorts and limits the results of a search.
+type SearchOptions struct {
+	// SortKey returns the value to sort the results by (results
+	// with the same value are sorted by ID). No particular order if nil.
+	SortKey    func(laptop *pb.Laptop) float64
+	Descending bool
+	Offset     int // number of results to skip
+	Limit      int // max number of results (0 = no limit)
+	// Match is a condition of the results besides the filter (none if nil).
+	Match func(laptop *pb.Laptop) bool
 }
 
 type ImageStore interface {
 	// Save saves the image to the store (and returns the ID of the saved image).
-	Save(laptopId string, imageType string, imageData bytes.Buffer) (string, error)
+	Save(laptopId string, imageType string, imageData io.Reader) (string, error)
+	// Create starts the upload of an image by user `owner` (empty if
+	// unknown), to write it in chunks. The image is saved only when the
+	// upload is committed, if it's a valid image of type `imageType`
+	// (any accepted type if empty).
+	Create(laptopId string, imageType string, owner string) (ImageUpload, error)
+	// Find finds the info of an image by ID (nil if there is no such image).
+	Find(imageId string) (*ImageInfo, error)
+	// Open opens an image, or one of its variants if `variant` is not 0,
+	// to read its data (returns `ErrorNotFound` if there is no such image).
+	Open(imageId string, variant int) (io.ReadCloser, error)
+	// SaveVariant saves a resized version of an image, as the variant
+	// of size `size` (returns `ErrorNotFound` if there is no such image).
+	SaveVariant(imageId string, size int, imageData io.Reader) error
+	// List returns the images of a laptop, the primary
+	// image first and then by upload time.
+	List(laptopId string) ([]*ImageInfo, error)
+	// SetPrimary makes an image the primary image of its laptop, i.e. the
+	// one to show first (returns `ErrorNotFound` if there is no such image).
+	SetPrimary(imageId string) error
+	// Delete deletes an image (returns `ErrorNotFound` if there is no such image).
+	Delete(imageId string) error
+	// DeleteByLaptop deletes all the images of a laptop.
+	DeleteByLaptop(laptopId string) error
+	// Usage returns the images of a laptop, and the images uploaded by
+	// user `owner` (to any laptop, none if `owner` is empty), without
+	// their variants.
+	Usage(laptopId string, owner string) (laptop ImageUsage, user ImageUsage, err error)
+}
+
+// ImageUsage is the number and the total size of some images.
+type ImageUsage struct {
+	Images int
+	Bytes  int64
+}
+
+// ImageUpload is an image being written to an image store.
+// It's not safe for concurrent use.
+type ImageUpload interface {
+	io.Writer
+	// Commit saves the image, and returns its info. Invalid images, and
+	// the ones of more than `maxPixels` pixels, are discarded (see the
+	// errors of package `images`).
+	Commit(maxPixels int) (*ImageInfo, error)
+	// Rewrite replaces the data written so far with the copy made by
+	// `rewrite` (e.g. without the metadata of the image).
+	Rewrite(rewrite func(w io.Writer, r io.Reader) error) error
+	// Size returns the size of the data written so far (in bytes),
+	// i.e. the size of the image once saved.
+	Size() int64
+	// Abort discards the image (nothing to do once committed).
+	Abort() error
 }
 
 type RatingStore interface {
-	// Add adds a new laptop score to the store and returns its rating.
-	Add(laptopId string, score float64) (*Rating, error)
+	// Rate saves the score of a laptop by a user, replacing the previous
+	// score of the user if any, and returns the rating of the laptop.
+	Rate(laptopId string, username string, score float64) (*Rating, error)
+	// Retract removes the score of a laptop by a user, and returns the
+	// rating of the laptop (returns `ErrorNotFound` if there is no such score).
+	Retract(laptopId string, username string) (*Rating, error)
+	// Find finds the rating of a laptop (nil if not rated).
+	Find(laptopId string) (*Rating, error)
+	// All returns a copy of the ratings of all the rated laptops.
+	All() (map[string]Rating, error)
+	// Stats returns the aggregates of the scores of the laptops, all read
+	// at the same time (the laptops that are not rated are left out).
+	Stats(laptopIds ...string) (map[string]*RatingStats, error)
+	// Delete deletes the rating of a laptop.
+	Delete(laptopId string) error
 }
 
 type Rating struct {
-	Count uint32  // number of times the laptop is reated
-	Sum   float64 // sum of all rated scores
+	Count uint32  // number of users who rated the laptop
+	Sum   float64 // sum of their scores
+}
+
+// RatingStats are the aggregates of the scores of a laptop.
+type RatingStats struct {
+	Count     uint32
+	Mean      float64
+	Median    float64
+	StdDev    float64       // population standard deviation
+	Histogram []ScoreBucket // one bucket per score given, by score
+}
+
+type ScoreBucket struct {
+	Score float64
+	Count uint32 // number of users who gave the score
+}
+
+// ReviewStore stores the reviews of the laptops, one per user and laptop.
+type ReviewStore interface {
+	// Save saves the review of a laptop by its author, replacing the
+	// previous one if any (keeping its ID, creation time and votes),
+	// and returns the saved review.
+	Save(review *pb.Review) (*pb.Review, error)
+	// Find finds the review of a laptop by a user (nil if there is none).
+	Find(laptopId string, author string) (*pb.Review, error)
+	// Vote adds (or withdraws) the helpful vote of a user for a review,
+	// and returns the review (returns `ErrorNotFound` if there is no such
+	// review, `ErrorOwnReview` if the user is its author).
+	Vote(reviewId string, voter string, helpful bool) (*pb.Review, error)
+	// List returns a page of at most `limit` reviews of a laptop after
+	// `cursor` (from the start if empty), and the cursor of the next
+	// page (empty on the last page).
+	List(laptopId string, orderBy pb.ListReviewsRequest_OrderBy, cursor string, limit int) ([]*pb.Review, string, error)
+	// Delete deletes the review of a laptop by a user (if any).
+	Delete(laptopId string, author string) error
+	// DeleteByLaptop deletes all the reviews of a laptop.
+	DeleteByLaptop(laptopId string) error
 }
 
 type UserStore interface {
@@ -0,0 +1,133 @@
+package stores
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+)
+
+// laptopSortKeys are the sort keys of a search that
+// depend only on the laptop itself.
+var laptopSortKeys = map[pb.SearchLaptopRequest_SortBy]func(laptop *pb.Laptop) float64{
+	pb.SearchLaptopRequest_PRICE: func(laptop *pb.Laptop) float64 {
+		return laptop.GetPriceUsd()
+	},
+	pb.SearchLaptopRequest_CPU_CORES: func(laptop *pb.Laptop) float64 {
+		return float64(laptop.GetCpu().GetNumberCores())
+	},
+	pb.SearchLaptopRequest_CPU_GHZ: func(laptop *pb.Laptop) float64 {
+		return laptop.GetCpu().GetMinGhz()
+	},
+	pb.SearchLaptopRequest_RAM: func(laptop *pb.Laptop) float64 {
+		return float64(ToBit(laptop.GetRam()))
+	},
+	pb.SearchLaptopRequest_RELEASE_YEAR: func(laptop *pb.Laptop) float64 {
+		return float64(laptop.GetReleaseYear())
+	},
+}
+
+// LaptopSortKey returns the `SearchOptions.SortKey` for `sortBy`,
+// or nil if the key doesn't depend only on the laptop (e.g. rating).
+func LaptopSortKey(sortBy pb.SearchLaptopRequest_SortBy) func(laptop *pb.Laptop) float64 {
+	return laptopSortKeys[sortBy]
+}
+
+type keyedLaptop struct {
+	laptop *pb.Laptop
+	key    float64
+}
+
+// topLaptops collects the results of a search, keeping only the
+// first `Offset + Limit` of them. When sorted it's a heap with the
+// last of the kept results on top, so that a new result either
+// replaces it or is discarded in O(log(Offset + Limit)).
+// Only pointers are kept, the laptops are not copied.
+type topLaptops struct {
+	options SearchOptions
+	size    int // 0 = no limit
+	items   []keyedLaptop
+}
+
+func newTopLaptops(options *SearchOptions) *topLaptops {
+	top := &topLaptops{}
+	if options != nil {
+		top.options = *options
+	}
+	if top.options.Limit > 0 {
+		top.size = top.options.Offset + top.options.Limit
+	}
+
+	return top
+}
+
+// before tells whether `a` comes before `b` in the results.
+func (top *topLaptops) before(a, b keyedLaptop) bool {
+	if a.key != b.key {
+		return (a.key < b.key) != top.options.Descending
+	}
+	return a.laptop.GetId() < b.laptop.GetId()
+}
+
+// Implementing `heap.Interface`: the last result goes on top.
+func (top *topLaptops) Len() int           { return len(top.items) }
+func (top *topLaptops) Less(i, j int) bool { return top.before(top.items[j], top.items[i]) }
+func (top *topLaptops) Swap(i, j int)      { top.items[i], top.items[j] = top.items[j], top.items[i] }
+func (top *topLaptops) Push(x interface{}) { top.items = append(top.items, x.(keyedLaptop)) }
+func (top *topLaptops) Pop() interface{} {
+	last := top.items[len(top.items)-1]
+	top.items = top.items[:len(top.items)-1]
+	return last
+}
+
+// match tells whether a laptop matching the
+// filter matches the other condition, if any.
+func (top *topLaptops) match(laptop *pb.Laptop) bool {
+	return top.options.Match == nil || top.options.Match(laptop)
+}
+
+// add adds a qualified laptop, and returns false when
+// no more laptops are needed.
+func (top *topLaptops) add(laptop *pb.Laptop) bool {
+	sortKey := top.options.SortKey
+
+	// Unsorted: the first results are as good as any other.
+	if sortKey == nil {
+		top.items = append(top.items, keyedLaptop{laptop: laptop})
+		return top.size == 0 || len(top.items) < top.size
+	}
+
+	item := keyedLaptop{laptop: laptop, key: sortKey(laptop)}
+	switch {
+	case top.size == 0:
+		top.items = append(top.items, item)
+	case len(top.items) < top.size:
+		heap.Push(top, item)
+	case top.before(item, top.items[0]):
+		top.items[0] = item
+		heap.Fix(top, 0)
+	}
+
+	return true
+}
+
+// results returns the kept laptops in order, skipping the offset.
+func (top *topLaptops) results() []*pb.Laptop {
+	if top.options.SortKey != nil {
+		sort.Slice(top.items, func(i, j int) bool {
+			return top.before(top.items[i], top.items[j])
+		})
+	}
+
+	if top.options.Offset >= len(top.items) {
+		return nil
+	}
+
+	items := top.items[top.options.Offset:]
+	laptops := make([]*pb.Laptop, 0, len(items))
+	for _, item := range items {
+		laptops = append(laptops, item.laptop)
+	}
+
+	return laptops
+}
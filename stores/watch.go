@@ -0,0 +1,116 @@
+package stores
+
+import (
+	"github.com/aleg/go-grpc-laptops/pb"
+)
+
+// Max number of changes kept by a store to resume watches.
+const maxLaptopChanges = 4096
+
+// Max number of changes a watcher can fall behind before
+// being dropped (it then catches up from the kept changes).
+const watcherBuffer = 64
+
+// laptopChange is a change of a laptop store: `old` is nil when
+// the laptop is created, `new` is nil when it's deleted.
+type laptopChange struct {
+	revision uint64
+	old      *pb.Laptop
+	new      *pb.Laptop
+}
+
+// laptopWatcher receives the changes of a store. Its channel is
+// closed when the watcher is dropped for falling behind, so that
+// writers never wait for a slow watcher.
+type laptopWatcher struct {
+	changes chan *laptopChange
+}
+
+// laptopChanges keeps the last changes of a store, and
+// sends the new ones to its watchers. It must be protected
+// by the lock of the store.
+type laptopChanges struct {
+	revision uint64 // incremented at each change
+	log      []*laptopChange
+	watchers map[*laptopWatcher]struct{}
+}
+
+func newLaptopChanges() *laptopChanges {
+	// Revisions start at 1, so that 0 is never a revision
+	// (it means "from the current laptops" for watches).
+	return &laptopChanges{
+		revision: 1,
+		watchers: make(map[*laptopWatcher]struct{}),
+	}
+}
+
+func (changes *laptopChanges) add(old *pb.Laptop, new *pb.Laptop) {
+	changes.revision++
+	change := &laptopChange{revision: changes.revision, old: old, new: new}
+
+	changes.log = append(changes.log, change)
+	if len(changes.log) > maxLaptopChanges {
+		changes.log = changes.log[len(changes.log)-maxLaptopChanges:]
+	}
+
+	for watcher := range changes.watchers {
+		select {
+		case watcher.changes <- change:
+		default:
+			close(watcher.changes)
+			delete(changes.watchers, watcher)
+		}
+	}
+}
+
+// since returns the changes after `revision`.
+func (changes *laptopChanges) since(revision uint64) ([]*laptopChange, error) {
+	if revision > changes.revision {
+		return nil, ErrorInvalidRevision
+	}
+
+	missed := changes.revision - revision
+	if missed > uint64(len(changes.log)) {
+		return nil, ErrorRevisionCompacted
+	}
+
+	log := changes.log[len(changes.log)-int(missed):]
+	return append([]*laptopChange(nil), log...), nil
+}
+
+func (changes *laptopChanges) watch() *laptopWatcher {
+	watcher := &laptopWatcher{changes: make(chan *laptopChange, watcherBuffer)}
+	changes.watchers[watcher] = struct{}{}
+	return watcher
+}
+
+func (changes *laptopChanges) unwatch(watcher *laptopWatcher) {
+	delete(changes.watchers, watcher)
+}
+
+// changeEvent returns the event of `change` for the watchers of
+// `filter`, or nil if the change doesn't concern them.
+func changeEvent(filter *pb.Filter, change *laptopChange) *LaptopEvent {
+	wasQualified := change.old != nil && isQualified(filter, change.old)
+	isNowQualified := change.new != nil && isQualified(filter, change.new)
+
+	event := &LaptopEvent{Revision: change.revision}
+	switch {
+	case wasQualified && isNowQualified:
+		event.Type = pb.WatchLaptopsResponse_UPDATED
+		event.Laptop = change.new
+	case isNowQualified:
+		event.Type = pb.WatchLaptopsResponse_CREATED
+		event.Laptop = change.new
+	case wasQualified:
+		event.Type = pb.WatchLaptopsResponse_DELETED
+		event.Laptop = change.new
+		if event.Laptop == nil {
+			event.Laptop = change.old
+		}
+	default:
+		return nil
+	}
+
+	return event
+}
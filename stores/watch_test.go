@@ -0,0 +1,167 @@
+package stores
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aleg/go-grpc-laptops/pb"
+	"github.com/aleg/go-grpc-laptops/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// startWatch watches `store` in the background, sending the
+// events to the returned channel.
+func startWatch(t *testing.T, store *InMemoryLaptopStore, filter *pb.Filter, revision uint64) (<-chan *LaptopEvent, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	events := make(chan *LaptopEvent, 1000)
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Watch(ctx, filter, revision, func(event *LaptopEvent) error {
+			events <- event
+			return nil
+		})
+	}()
+
+	return events, done
+}
+
+func nextEvent(t *testing.T, events <-chan *LaptopEvent) *LaptopEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event received")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	filter := &pb.Filter{MinCpuCores: 4}
+
+	newLaptop := func(cores uint32) *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.Cpu.NumberCores = cores
+		err := store.Save(laptop)
+		require.NoError(t, err)
+
+		laptop, err = store.Find(laptop.GetId())
+		require.NoError(t, err)
+		return laptop
+	}
+	update := func(laptop *pb.Laptop, cores uint32) *pb.Laptop {
+		laptop = proto.Clone(laptop).(*pb.Laptop)
+		laptop.Cpu.NumberCores = cores
+		laptop, err := store.Update(laptop)
+		require.NoError(t, err)
+		return laptop
+	}
+	requireEvent := func(events <-chan *LaptopEvent, eventType pb.WatchLaptopsResponse_EventType, laptop *pb.Laptop) *LaptopEvent {
+		event := nextEvent(t, events)
+		require.Equal(t, eventType, event.Type)
+		require.Equal(t, laptop.GetId(), event.Laptop.GetId())
+		require.Equal(t, laptop.GetCpu().GetNumberCores(), event.Laptop.GetCpu().GetNumberCores())
+		return event
+	}
+
+	matching := newLaptop(8)
+	notMatching := newLaptop(2)
+
+	// The current matches first.
+	events, _ := startWatch(t, store, filter, 0)
+	requireEvent(events, pb.WatchLaptopsResponse_CREATED, matching)
+	synced := nextEvent(t, events)
+	require.Equal(t, pb.WatchLaptopsResponse_SYNCED, synced.Type)
+	require.Nil(t, synced.Laptop)
+
+	// Then the changes, according to the filter.
+	created := newLaptop(4)
+	requireEvent(events, pb.WatchLaptopsResponse_CREATED, created)
+	notMatching = update(notMatching, 6)
+	requireEvent(events, pb.WatchLaptopsResponse_CREATED, notMatching)
+	matching = update(matching, 16)
+	requireEvent(events, pb.WatchLaptopsResponse_UPDATED, matching)
+	updated := update(created, 1)
+	deleted := requireEvent(events, pb.WatchLaptopsResponse_DELETED, updated)
+	newLaptop(1)
+	err := store.Delete(matching.GetId())
+	require.NoError(t, err)
+	last := requireEvent(events, pb.WatchLaptopsResponse_DELETED, matching)
+	require.Greater(t, last.Revision, deleted.Revision)
+
+	// Resuming a watch.
+	events, _ = startWatch(t, store, filter, synced.Revision)
+	requireEvent(events, pb.WatchLaptopsResponse_CREATED, created)
+	requireEvent(events, pb.WatchLaptopsResponse_CREATED, notMatching)
+	requireEvent(events, pb.WatchLaptopsResponse_UPDATED, matching)
+	requireEvent(events, pb.WatchLaptopsResponse_DELETED, updated)
+	resumed := requireEvent(events, pb.WatchLaptopsResponse_DELETED, matching)
+	require.Equal(t, last.Revision, resumed.Revision)
+
+	// Invalid revision.
+	_, done := startWatch(t, store, filter, last.Revision+1)
+	require.ErrorIs(t, <-done, ErrorInvalidRevision)
+}
+
+func TestWatchSlowWatcher(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	synced := make(chan struct{})
+	resume := make(chan struct{})
+	events := make(chan *LaptopEvent, 1000)
+	go func() {
+		_ = store.Watch(ctx, nil, 0, func(event *LaptopEvent) error {
+			if event.Type == pb.WatchLaptopsResponse_SYNCED {
+				close(synced)
+				<-resume // stalled watcher
+				return nil
+			}
+			events <- event
+			return nil
+		})
+	}()
+	<-synced
+
+	// The writers don't wait for the stalled watcher...
+	n := 3 * watcherBuffer
+	for i := 0; i < n; i++ {
+		err := store.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	// ...which gets all the changes anyway.
+	close(resume)
+	for i := 0; i < n; i++ {
+		event := nextEvent(t, events)
+		require.Equal(t, pb.WatchLaptopsResponse_CREATED, event.Type)
+		require.Equal(t, uint64(i+2), event.Revision)
+	}
+}
+
+func TestWatchCompacted(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < maxLaptopChanges+1; i++ {
+		err := store.Save(sample.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	_, done := startWatch(t, store, nil, 1)
+	require.ErrorIs(t, <-done, ErrorRevisionCompacted)
+
+	events, _ := startWatch(t, store, nil, 2)
+	event := nextEvent(t, events)
+	require.Equal(t, uint64(3), event.Revision)
+}
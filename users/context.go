@@ -0,0 +1,18 @@
+package users
+
+import "context"
+
+// claimsKey is the context key of the claims of the user of a request.
+type claimsKey struct{}
+
+// NewContext returns a copy of `ctx` carrying the claims of a user.
+func NewContext(ctx context.Context, claims *UserClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the user of a request
+// (nil if the user is not authenticated).
+func FromContext(ctx context.Context) *UserClaims {
+	claims, _ := ctx.Value(claimsKey{}).(*UserClaims)
+	return claims
+}